@@ -2,6 +2,7 @@ package fpc
 
 import (
 	"bytes"
+	"io"
 	"io/ioutil"
 	"math"
 	"math/rand"
@@ -134,6 +135,26 @@ func BenchmarkReadFloats(b *testing.B) {
 	}
 }
 
+func BenchmarkReadFloatsStream(b *testing.B) {
+	vals := generateFloats(1 << 20)
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	for _, f := range vals {
+		w.WriteFloat(f)
+	}
+	w.Close()
+	in := bytes.NewReader(buf.Bytes())
+	out := make([]float64, len(vals))
+
+	b.SetBytes(int64(8 * len(vals)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		in.Seek(0, io.SeekStart)
+		r := NewReader(in)
+		r.ReadFloats(out)
+	}
+}
+
 func BenchmarkWriter(b *testing.B) {
 	b.SetBytes(int64(len(benchcase.uncompressed) * 8))
 	w, _ := NewWriterLevel(ioutil.Discard, int(benchcase.comp))
@@ -142,3 +163,25 @@ func BenchmarkWriter(b *testing.B) {
 		w.WriteFloat(benchcase.uncompressed[i%len(benchcase.uncompressed)])
 	}
 }
+
+func BenchmarkWriteFloats(b *testing.B) {
+	vals := generateFloats(1 << 20)
+	w := NewWriter(ioutil.Discard)
+
+	b.SetBytes(int64(8 * len(vals)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.WriteFloats(vals)
+	}
+}
+
+func BenchmarkWriterReset(b *testing.B) {
+	w := NewWriter(ioutil.Discard)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.Reset(ioutil.Discard)
+		w.WriteFloats(benchcase.uncompressed)
+		w.Close()
+	}
+}
@@ -9,11 +9,12 @@ import (
 	"github.com/spenczar/fpc"
 )
 
-const bufferSize = 1024
-
 func main() {
 	decompress := flag.Bool("d", false, "Decompress input data and write output to stdout.")
 	level := flag.Int("l", fpc.DefaultCompression, "Compression level to use when compressing. Ignored when decompressing.")
+	workers := flag.Int("p", 1, "Number of goroutines to use. When compressing, values above 1 produce a segmented stream.")
+	checksums := flag.Bool("c", false, "Write a checksum after each block when compressing.")
+	framed := flag.Bool("f", false, "Write a self-describing framed header when compressing.")
 	help := flag.Bool("h", false, "Print this help text")
 	flag.Parse()
 
@@ -23,9 +24,14 @@ func main() {
 	}
 
 	if *decompress {
-		decompressStream(os.Stdin, os.Stdout)
+		decompressStream(os.Stdin, os.Stdout, *workers)
 	} else {
-		compressStream(os.Stdin, os.Stdout, *level)
+		opts := fpc.WriterOptions{
+			Level:     *level,
+			Checksums: *checksums,
+			Framed:    *framed,
+		}
+		compressStream(os.Stdin, os.Stdout, opts, *workers)
 	}
 }
 
@@ -34,41 +40,40 @@ func fatal(err error) {
 	os.Exit(1)
 }
 
-func compressStream(in io.Reader, out io.Writer, level int) {
-	w, err := fpc.NewWriterLevel(out, level)
+func compressStream(in io.Reader, out io.Writer, opts fpc.WriterOptions, workers int) {
+	var (
+		w   io.WriteCloser
+		err error
+	)
+	if workers > 1 {
+		w, err = fpc.NewParallelWriterOptions(out, opts, workers)
+	} else {
+		w, err = fpc.NewWriterOptions(out, opts)
+	}
 	if err != nil {
 		fatal(err)
 	}
-
-	buf := make([]byte, bufferSize)
-	for {
-		n, err := in.Read(buf)
-		//log.Printf("bytes off wire: %#v", buf[:n])
-		if err == io.EOF {
-			w.Write(buf[:n])
-			err = w.Close()
-			if err != nil {
-				fatal(err)
-			}
-			return
-		} else if err != nil {
-			fatal(err)
-		}
-		w.Write(buf[:n])
+	if _, err := io.Copy(w, in); err != nil {
+		fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		fatal(err)
 	}
 }
 
-func decompressStream(in io.Reader, out io.Writer) {
-	r := fpc.NewReader(os.Stdin)
-	buf := make([]byte, bufferSize)
-	for {
-		n, err := r.Read(buf)
-		if err == io.EOF {
-			out.Write(buf[:n])
-			return
-		} else if err != nil {
+func decompressStream(in io.Reader, out io.Writer, workers int) {
+	var r io.Reader
+	if workers > 1 {
+		pr, err := fpc.NewParallelReader(in, workers)
+		if err != nil {
 			fatal(err)
 		}
-		out.Write(buf[:n])
+		defer pr.Close()
+		r = pr
+	} else {
+		r = fpc.NewReader(in)
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		fatal(err)
 	}
 }
@@ -0,0 +1,317 @@
+package fpc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// A column file holds the float fields of a slice of structs, with each
+// field compressed as its own FPC stream. It starts with a schema header:
+// magic bytes, a version byte, the number of rows as a little-endian 64-bit
+// integer, and the number of columns as a little-endian 16-bit integer. Each
+// column is then described by a length byte and the bytes of its name, an
+// element type byte, and the length of its stream as a little-endian 64-bit
+// integer. The columns' streams follow the header, in the same order.
+
+// columnsMagic identifies a column file. Its first byte can't be mistaken
+// for the start of an FPC stream with a raw header.
+var columnsMagic = []byte{0xFF, 'C', 'O', 'L'}
+
+const (
+	// ColumnsVersion is the version of the column file format written by
+	// this package.
+	ColumnsVersion = 1
+
+	maxColumns = 1<<16 - 1
+)
+
+// A column describes a struct field which is stored as a column.
+type column struct {
+	name  string
+	index int // index of the field in its struct
+	elem  ElementType
+}
+
+// structColumns returns the columns for the float fields of struct type t.
+// Exported fields of kind float64 or float32 are stored, named after the
+// field, unless the field's tag says otherwise: `fpc:"name"` renames the
+// column, and `fpc:"-"` leaves the field out.
+func structColumns(t reflect.Type) ([]column, error) {
+	var cols []column
+	names := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+		var elem ElementType
+		switch f.Type.Kind() {
+		case reflect.Float64:
+			elem = Float64
+		case reflect.Float32:
+			elem = Float32
+		default:
+			continue
+		}
+		name := f.Name
+		if tag := f.Tag.Get("fpc"); tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		if len(name) > 255 {
+			return nil, fmt.Errorf("fpc: column name %q is too long", name)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("fpc: %v has more than one column named %q", t, name)
+		}
+		names[name] = true
+		cols = append(cols, column{name: name, index: i, elem: elem})
+	}
+	if len(cols) > maxColumns {
+		return nil, fmt.Errorf("fpc: %v has too many float fields", t)
+	}
+	return cols, nil
+}
+
+// sliceOfStructs returns the value and element type of rows, which must be a
+// slice of structs, or a pointer to one.
+func sliceOfStructs(rows interface{}) (reflect.Value, reflect.Type, error) {
+	v := reflect.ValueOf(rows)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Slice || v.Type().Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, nil, fmt.Errorf("fpc: %T is not a slice of structs", rows)
+	}
+	return v, v.Type().Elem(), nil
+}
+
+// WriteColumns compresses the float fields of rows, which must be a slice of
+// structs, and writes them to w. Each field is compressed as its own FPC
+// stream, in the format described by opts, so that its values are predicted
+// only from the same field of earlier rows. Other fields are not written.
+//
+// Fields of kind float64 and float32 are written, unless they are unexported
+// or tagged `fpc:"-"`. Each column is named after its field, or after the
+// field's tag if it has one, like `fpc:"temp"`. No two columns may share a
+// name.
+func WriteColumns(w io.Writer, rows interface{}, opts WriterOptions) error {
+	v, t, err := sliceOfStructs(rows)
+	if err != nil {
+		return err
+	}
+	cols, err := structColumns(t)
+	if err != nil {
+		return err
+	}
+	if len(cols) == 0 {
+		return fmt.Errorf("fpc: %v has no float fields", t)
+	}
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	// Compress every column before writing the header, which records the
+	// length of each column's stream.
+	streams := make([]bytes.Buffer, len(cols))
+	for i, col := range cols {
+		if err := writeColumn(&streams[i], v, col, opts); err != nil {
+			return err
+		}
+	}
+
+	hdr := append([]byte(nil), columnsMagic...)
+	hdr = append(hdr, ColumnsVersion)
+	hdr = appendUint64(hdr, uint64(v.Len()))
+	hdr = append(hdr, byte(len(cols)), byte(len(cols)>>8))
+	for i, col := range cols {
+		hdr = append(hdr, byte(len(col.name)))
+		hdr = append(hdr, col.name...)
+		hdr = append(hdr, byte(col.elem))
+		hdr = appendUint64(hdr, uint64(streams[i].Len()))
+	}
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+	for i := range streams {
+		if _, err := streams[i].WriteTo(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeColumn compresses the field of each row of v described by col to w.
+func writeColumn(w io.Writer, v reflect.Value, col column, opts WriterOptions) error {
+	if col.elem == Float32 {
+		z, err := NewWriter32Options(w, opts)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := z.WriteFloat32(float32(v.Index(i).Field(col.index).Float())); err != nil {
+				return err
+			}
+		}
+		return z.Close()
+	}
+
+	z, err := NewWriterOptions(w, opts)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < v.Len(); i++ {
+		if err := z.WriteFloat(v.Index(i).Field(col.index).Float()); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+// ReadColumns reads a slice of structs written by WriteColumns from r, and
+// stores it in the slice pointed to by rows, replacing its contents. The
+// struct type needn't be the one which was written: each column is stored in
+// the float field with the same name, converting between float32 and
+// float64 if necessary, and columns which match no field are skipped. Fields
+// which match no column are left as zero values.
+func ReadColumns(r io.Reader, rows interface{}) error {
+	if v := reflect.ValueOf(rows); v.Kind() != reflect.Ptr || v.IsNil() {
+		return errors.New("fpc.ReadColumns: rows must be a non-nil pointer to a slice of structs")
+	}
+	v, t, err := sliceOfStructs(rows)
+	if err != nil {
+		return err
+	}
+	fields, err := structColumns(t)
+	if err != nil {
+		return err
+	}
+
+	nRows, cols, lengths, err := readColumnsHeader(r)
+	if err != nil {
+		return err
+	}
+	if len(cols) == 0 {
+		return dataError("column file has no columns")
+	}
+
+	// Every column is decoded, even those which match no field, to check
+	// that it has the right number of values. Rows aren't touched until all
+	// of the columns have been checked, and the number of rows comes from
+	// the decoded data, so that a corrupt row count can't cause a huge
+	// allocation.
+	colVals := make([][]float64, len(cols))
+	for i, col := range cols {
+		cr := &io.LimitedReader{R: r, N: int64(lengths[i])}
+		vals, err := readColumn(cr, col.elem)
+		if err != nil {
+			return err
+		}
+		if cr.N != 0 {
+			return dataErrorf("column %q shorter than its recorded length", col.name)
+		}
+		if uint64(len(vals)) != nRows {
+			return dataErrorf("column %q has %d values, want %d", col.name, len(vals), nRows)
+		}
+		colVals[i] = vals
+	}
+
+	n := len(colVals[0])
+	v.Set(reflect.MakeSlice(v.Type(), n, n))
+	for i, col := range cols {
+		for _, f := range fields {
+			if f.name != col.name {
+				continue
+			}
+			for j, val := range colVals[i] {
+				v.Index(j).Field(f.index).SetFloat(val)
+			}
+		}
+	}
+	return nil
+}
+
+// readColumnsHeader reads the schema header at the start of a column file.
+// The columns it returns have no field index.
+func readColumnsHeader(r io.Reader) (nRows uint64, cols []column, lengths []uint64, err error) {
+	read := func(n int) ([]byte, error) {
+		b := make([]byte, n)
+		_, err := io.ReadFull(r, b)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, dataError("column header too short")
+		}
+		return b, err
+	}
+
+	b, err := read(len(columnsMagic) + 1 + 8 + 2)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if !bytes.HasPrefix(b, columnsMagic) {
+		return 0, nil, nil, dataError("missing column header magic")
+	}
+	b = b[len(columnsMagic):]
+	if b[0] != ColumnsVersion {
+		return 0, nil, nil, dataErrorf("unsupported column format version %d", b[0])
+	}
+	nRows = byteOrder.Uint64(b[1:9])
+	cols = make([]column, byteOrder.Uint16(b[9:11]))
+	lengths = make([]uint64, len(cols))
+	names := make(map[string]bool)
+	for i := range cols {
+		if b, err = read(1); err != nil {
+			return 0, nil, nil, err
+		}
+		if b, err = read(int(b[0]) + 1 + 8); err != nil {
+			return 0, nil, nil, err
+		}
+		name := string(b[:len(b)-9])
+		if names[name] {
+			return 0, nil, nil, dataErrorf("duplicate column name %q", name)
+		}
+		names[name] = true
+		cols[i] = column{name: name, index: -1, elem: ElementType(b[len(b)-9])}
+		if cols[i].elem.size() == 0 {
+			return 0, nil, nil, dataErrorf("unsupported element type %v", cols[i].elem)
+		}
+		lengths[i] = byteOrder.Uint64(b[len(b)-8:])
+	}
+	return nRows, cols, lengths, nil
+}
+
+// readColumn decodes all of the values in the column stream in r, which
+// holds values of type elem.
+func readColumn(r io.Reader, elem ElementType) ([]float64, error) {
+	var vals []float64
+	if elem == Float32 {
+		z := NewReader32(r)
+		buf := make([]float32, 4096)
+		for {
+			n, err := z.ReadFloat32s(buf)
+			for _, f := range buf[:n] {
+				vals = append(vals, float64(f))
+			}
+			if err == io.EOF {
+				return vals, nil
+			} else if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	z := NewReader(r)
+	buf := make([]float64, 4096)
+	for {
+		n, err := z.ReadFloats(buf)
+		vals = append(vals, buf[:n]...)
+		if err == io.EOF {
+			return vals, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
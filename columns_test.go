@@ -0,0 +1,178 @@
+package fpc
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+type telemetry struct {
+	Time     int64 // not stored
+	Temp     float64
+	Pressure float64 `fpc:"pres"`
+	Humidity float32
+	Voltage  float64 `fpc:"-"`
+	internal float64
+}
+
+func generateTelemetry(n int) []telemetry {
+	rows := make([]telemetry, n)
+	for i := range rows {
+		rows[i] = telemetry{
+			Time:     int64(i),
+			Temp:     20 + 5*math.Sin(float64(i)/300),
+			Pressure: 1013 + float64(i%40)*0.1,
+			Humidity: float32(40 + i%9),
+			Voltage:  3.3,
+			internal: 1,
+		}
+	}
+	return rows
+}
+
+func TestColumns(t *testing.T) {
+	for _, n := range []int{0, 1, 3*maxRecordsPerBlock + 17} {
+		rows := generateTelemetry(n)
+		buf := new(bytes.Buffer)
+		if err := WriteColumns(buf, rows, WriterOptions{Level: 12}); err != nil {
+			t.Fatalf("WriteColumns n=%d err=%q", n, err)
+		}
+
+		var have []telemetry
+		if err := ReadColumns(bytes.NewReader(buf.Bytes()), &have); err != nil {
+			t.Fatalf("ReadColumns n=%d err=%q", n, err)
+		}
+		if len(have) != n {
+			t.Fatalf("ReadColumns n=%d read %d rows", n, len(have))
+		}
+		for i := range rows {
+			want := telemetry{
+				Temp:     rows[i].Temp,
+				Pressure: rows[i].Pressure,
+				Humidity: rows[i].Humidity,
+			}
+			if have[i] != want {
+				t.Fatalf("row mismatch n=%d idx=%d have=%+v want=%+v", n, i, have[i], want)
+			}
+		}
+	}
+}
+
+func TestColumnsBeatRows(t *testing.T) {
+	rows := generateTelemetry(50000)
+	cols := new(bytes.Buffer)
+	if err := WriteColumns(cols, rows, WriterOptions{Level: 12}); err != nil {
+		t.Fatalf("WriteColumns err=%q", err)
+	}
+
+	flat := new(bytes.Buffer)
+	w, err := NewWriterLevel(flat, 12)
+	if err != nil {
+		t.Fatalf("NewWriterLevel err=%q", err)
+	}
+	for _, row := range rows {
+		w.WriteFloats([]float64{row.Temp, row.Pressure, float64(row.Humidity)})
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+	if cols.Len() >= flat.Len() {
+		t.Errorf("columns take %d bytes, want less than %d for rows", cols.Len(), flat.Len())
+	}
+}
+
+func TestColumnsSchemaChange(t *testing.T) {
+	rows := generateTelemetry(100)
+	buf := new(bytes.Buffer)
+	if err := WriteColumns(buf, rows, WriterOptions{Level: 4}); err != nil {
+		t.Fatalf("WriteColumns err=%q", err)
+	}
+
+	// Columns are matched to fields by name, whatever their order or type.
+	// Unmatched columns and fields are ignored.
+	type reading struct {
+		Humidity float64
+		Pressure float32 `fpc:"pres"`
+		Wind     float64
+	}
+	var have []reading
+	if err := ReadColumns(bytes.NewReader(buf.Bytes()), &have); err != nil {
+		t.Fatalf("ReadColumns err=%q", err)
+	}
+	if len(have) != len(rows) {
+		t.Fatalf("ReadColumns read %d rows, want %d", len(have), len(rows))
+	}
+	for i := range rows {
+		want := reading{Humidity: float64(rows[i].Humidity), Pressure: float32(rows[i].Pressure)}
+		if have[i] != want {
+			t.Fatalf("row mismatch idx=%d have=%+v want=%+v", i, have[i], want)
+		}
+	}
+}
+
+func TestColumnsInvalid(t *testing.T) {
+	type noFloats struct{ A int }
+	for _, rows := range []interface{}{
+		nil,
+		[]float64{1},
+		[]noFloats{{1}},
+	} {
+		if err := WriteColumns(new(bytes.Buffer), rows, WriterOptions{Level: 4}); err == nil {
+			t.Errorf("WriteColumns of %T should fail", rows)
+		}
+	}
+
+	var rows []telemetry
+	if err := ReadColumns(bytes.NewReader(nil), rows); err == nil {
+		t.Errorf("ReadColumns into a slice, rather than a pointer, should fail")
+	}
+
+	buf := new(bytes.Buffer)
+	if err := WriteColumns(buf, generateTelemetry(1000), WriterOptions{Level: 4}); err != nil {
+		t.Fatalf("WriteColumns err=%q", err)
+	}
+	data := buf.Bytes()
+	corrupt := func(f func(b []byte)) []byte {
+		b := append([]byte(nil), data...)
+		f(b)
+		return b
+	}
+	for i, in := range [][]byte{
+		data[:3],
+		data[:len(data)-1],
+		corrupt(func(b []byte) { b[1] = 'X' }), // magic
+		corrupt(func(b []byte) { b[4] = ColumnsVersion + 1 }),       // version
+		corrupt(func(b []byte) { byteOrder.PutUint64(b[5:], 999) }), // row count
+		corrupt(func(b []byte) { byteOrder.PutUint64(b[5:], 1<<62) }),
+	} {
+		err := ReadColumns(bytes.NewReader(in), &rows)
+		if _, ok := err.(DataError); !ok {
+			t.Errorf("ReadColumns test=%d err=%v, want DataError", i, err)
+		}
+	}
+
+	// Columns which share a name can't be told apart.
+	type dupFields struct {
+		A float64 `fpc:"x"`
+		B float64 `fpc:"x"`
+	}
+	if err := WriteColumns(new(bytes.Buffer), []dupFields{{1, 2}}, WriterOptions{Level: 4}); err == nil {
+		t.Errorf("WriteColumns with duplicate column names should fail")
+	}
+	dup := append([]byte(nil), columnsMagic...)
+	dup = append(dup, ColumnsVersion)
+	dup = appendUint64(dup, 0)
+	dup = append(dup, 2, 0)
+	for i := 0; i < 2; i++ {
+		dup = append(dup, 1, 'x', byte(Float64))
+		dup = appendUint64(dup, 0)
+	}
+	if err := ReadColumns(bytes.NewReader(dup), &rows); err == nil {
+		t.Errorf("ReadColumns with duplicate column names should fail")
+	} else if _, ok := err.(DataError); !ok {
+		t.Errorf("ReadColumns with duplicate column names err=%v, want DataError", err)
+	}
+	if len(rows) != 0 {
+		t.Errorf("ReadColumns of corrupt data stored %d rows", len(rows))
+	}
+}
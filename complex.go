@@ -0,0 +1,258 @@
+package fpc
+
+import (
+	"io"
+	"math"
+)
+
+// Complex values are stored as streams with two channels, like those written
+// by a MultiWriter: the real part of each value, then its imaginary part.
+// Each part has its own predictors, so they don't disturb each other's
+// predictions.
+
+// A ComplexWriter FPC-compresses complex128 values. Streams written by a
+// ComplexWriter always have a framed header.
+type ComplexWriter struct {
+	w *Writer
+}
+
+// NewComplexWriter makes a new ComplexWriter which writes compressed data to
+// w using a provided compression level. It returns an error if an invalid
+// compression level is provided.
+func NewComplexWriter(w io.Writer, level int) (*ComplexWriter, error) {
+	return NewComplexWriterOptions(w, WriterOptions{Level: level})
+}
+
+// NewComplexWriterOptions makes a new ComplexWriter which writes compressed
+// data to w in the format described by opts. It returns an error if opts are
+// invalid.
+func NewComplexWriterOptions(w io.Writer, opts WriterOptions) (*ComplexWriter, error) {
+	opts.channels = 2
+	z, err := newWriter(w, opts, Float64)
+	if err != nil {
+		return nil, err
+	}
+	return &ComplexWriter{w: z}, nil
+}
+
+// WriteComplex128s writes a slice of complex128 values to the encoded stream.
+// It returns the number of values written.
+func (w *ComplexWriter) WriteComplex128s(cs []complex128) (int, error) {
+	if err := w.w.ensureHeader(); err != nil {
+		return 0, err
+	}
+	for i, c := range cs {
+		if err := w.w.enc.encode(math.Float64bits(real(c))); err != nil {
+			return i, err
+		}
+		if err := w.w.enc.encode(math.Float64bits(imag(c))); err != nil {
+			return i, err
+		}
+	}
+	return len(cs), nil
+}
+
+// Flush will make sure all internally-buffered values are written to the
+// underlying io.Writer, even if it results in a partial block. It does not
+// flush the underlying io.Writer.
+func (w *ComplexWriter) Flush() error {
+	return w.w.Flush()
+}
+
+// Close will flush the ComplexWriter and make any subsequent writes return
+// errors. It does not close the underlying io.Writer.
+func (w *ComplexWriter) Close() error {
+	return w.w.Close()
+}
+
+// Reset discards the ComplexWriter's state and makes it equivalent to the
+// result of its original constructor, but writing to dst instead, reusing
+// its predictor tables.
+func (w *ComplexWriter) Reset(dst io.Writer) {
+	w.w.Reset(dst)
+}
+
+// Stats reports what the ComplexWriter has compressed since it was created
+// or last reset. The real and imaginary parts of each value count
+// separately.
+func (w *ComplexWriter) Stats() Stats {
+	return w.w.Stats()
+}
+
+// A ComplexWriter64 FPC-compresses complex64 values. Streams written by a
+// ComplexWriter64 always have a framed header.
+type ComplexWriter64 struct {
+	w *Writer
+}
+
+// NewComplexWriter64 makes a new ComplexWriter64 which writes compressed
+// data to w using a provided compression level. It returns an error if an
+// invalid compression level is provided.
+func NewComplexWriter64(w io.Writer, level int) (*ComplexWriter64, error) {
+	return NewComplexWriter64Options(w, WriterOptions{Level: level})
+}
+
+// NewComplexWriter64Options makes a new ComplexWriter64 which writes
+// compressed data to w in the format described by opts. It returns an error
+// if opts are invalid.
+func NewComplexWriter64Options(w io.Writer, opts WriterOptions) (*ComplexWriter64, error) {
+	opts.channels = 2
+	z, err := newWriter(w, opts, Float32)
+	if err != nil {
+		return nil, err
+	}
+	return &ComplexWriter64{w: z}, nil
+}
+
+// WriteComplex64s writes a slice of complex64 values to the encoded stream.
+// It returns the number of values written.
+func (w *ComplexWriter64) WriteComplex64s(cs []complex64) (int, error) {
+	if err := w.w.ensureHeader(); err != nil {
+		return 0, err
+	}
+	for i, c := range cs {
+		if err := w.w.enc.encode(uint64(math.Float32bits(real(c)))); err != nil {
+			return i, err
+		}
+		if err := w.w.enc.encode(uint64(math.Float32bits(imag(c)))); err != nil {
+			return i, err
+		}
+	}
+	return len(cs), nil
+}
+
+// Flush will make sure all internally-buffered values are written to the
+// underlying io.Writer, even if it results in a partial block. It does not
+// flush the underlying io.Writer.
+func (w *ComplexWriter64) Flush() error {
+	return w.w.Flush()
+}
+
+// Close will flush the ComplexWriter64 and make any subsequent writes return
+// errors. It does not close the underlying io.Writer.
+func (w *ComplexWriter64) Close() error {
+	return w.w.Close()
+}
+
+// Reset discards the ComplexWriter64's state and makes it equivalent to the
+// result of its original constructor, but writing to dst instead, reusing
+// its predictor tables.
+func (w *ComplexWriter64) Reset(dst io.Writer) {
+	w.w.Reset(dst)
+}
+
+// Stats reports what the ComplexWriter64 has compressed since it was created
+// or last reset. The real and imaginary parts of each value count
+// separately.
+func (w *ComplexWriter64) Stats() Stats {
+	return w.w.Stats()
+}
+
+// A ComplexReader reads complex128 values from a stream written by a
+// ComplexWriter.
+type ComplexReader struct {
+	r *Reader
+}
+
+// NewComplexReader creates a new ComplexReader which reads and decompresses
+// FPC data from the given io.Reader.
+func NewComplexReader(r io.Reader) *ComplexReader {
+	return &ComplexReader{r: NewReader(r)}
+}
+
+// ReadComplex128s reads values into cs, returning the number read. If no
+// more values are available, ReadComplex128s returns with err==io.EOF. It
+// returns a DataError if the stream doesn't hold complex values, or ends
+// partway through one.
+func (r *ComplexReader) ReadComplex128s(cs []complex128) (int, error) {
+	return readComplex(r.r, len(cs), func(i int, re, im uint64) {
+		cs[i] = complex(math.Float64frombits(re), math.Float64frombits(im))
+	})
+}
+
+// Header returns the header describing the format of the stream, reading it
+// from the underlying io.Reader if necessary.
+func (r *ComplexReader) Header() (Header, error) {
+	return r.r.Header()
+}
+
+// Reset discards the ComplexReader's state and makes it equivalent to the
+// result of its original constructor, but reading from src instead, reusing
+// its predictor tables where possible.
+func (r *ComplexReader) Reset(src io.Reader) {
+	r.r.Reset(src)
+}
+
+// Stats reports what the ComplexReader has decoded since it was created or
+// last reset. The real and imaginary parts of each value count separately.
+func (r *ComplexReader) Stats() Stats {
+	return r.r.Stats()
+}
+
+// A ComplexReader64 reads complex64 values from a stream written by a
+// ComplexWriter64.
+type ComplexReader64 struct {
+	r *Reader
+}
+
+// NewComplexReader64 creates a new ComplexReader64 which reads and
+// decompresses FPC data from the given io.Reader.
+func NewComplexReader64(r io.Reader) *ComplexReader64 {
+	return &ComplexReader64{r: &Reader{r: r, elem: Float32}}
+}
+
+// ReadComplex64s reads values into cs, returning the number read. If no more
+// values are available, ReadComplex64s returns with err==io.EOF. It returns
+// a DataError if the stream doesn't hold complex values, or ends partway
+// through one.
+func (r *ComplexReader64) ReadComplex64s(cs []complex64) (int, error) {
+	return readComplex(r.r, len(cs), func(i int, re, im uint64) {
+		cs[i] = complex(math.Float32frombits(uint32(re)), math.Float32frombits(uint32(im)))
+	})
+}
+
+// Header returns the header describing the format of the stream, reading it
+// from the underlying io.Reader if necessary.
+func (r *ComplexReader64) Header() (Header, error) {
+	return r.r.Header()
+}
+
+// Reset discards the ComplexReader64's state and makes it equivalent to the
+// result of its original constructor, but reading from src instead, reusing
+// its predictor tables where possible.
+func (r *ComplexReader64) Reset(src io.Reader) {
+	r.r.Reset(src)
+}
+
+// Stats reports what the ComplexReader64 has decoded since it was created or
+// last reset. The real and imaginary parts of each value count separately.
+func (r *ComplexReader64) Stats() Stats {
+	return r.r.Stats()
+}
+
+// readComplex reads up to n complex values from r, passing the bits of each
+// one's real and imaginary parts to set. It returns the number of values
+// read.
+func readComplex(r *Reader, n int, set func(i int, re, im uint64)) (int, error) {
+	h, err := r.Header()
+	if err != nil {
+		return 0, err
+	}
+	if h.channels() != 2 {
+		return 0, dataError("stream doesn't hold complex values")
+	}
+	var parts [2]uint64
+	for i := 0; i < n; i++ {
+		for j := range parts {
+			vals, err := r.values(1)
+			if err == io.EOF && j == 1 {
+				return i, dataError("stream ends partway through a complex value")
+			} else if err != nil {
+				return i, err
+			}
+			parts[j] = vals[0]
+		}
+		set(i, parts[0], parts[1])
+	}
+	return n, nil
+}
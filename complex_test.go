@@ -0,0 +1,125 @@
+package fpc
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"math/cmplx"
+	"testing"
+)
+
+// generateSpectrum makes n values like the output of an FFT of a noisy
+// signal: smoothly varying magnitudes with rotating phases.
+func generateSpectrum(n int) []complex128 {
+	cs := make([]complex128, n)
+	for i := range cs {
+		mag := 100 / (1 + float64(i%1000)/50)
+		cs[i] = cmplx.Rect(mag, float64(i)/40)
+	}
+	return cs
+}
+
+func TestComplex128(t *testing.T) {
+	want := generateSpectrum(maxRecordsPerBlock + 3)
+	want = append(want, complex(math.Inf(1), math.NaN()), 0)
+
+	buf := new(bytes.Buffer)
+	w, err := NewComplexWriter(buf, 12)
+	if err != nil {
+		t.Fatalf("NewComplexWriter err=%q", err)
+	}
+	if n, err := w.WriteComplex128s(want); err != nil || n != len(want) {
+		t.Fatalf("WriteComplex128s n=%d err=%v", n, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+
+	// Interleaving the parts through a single set of predictors
+	// compresses worse.
+	flat := new(bytes.Buffer)
+	fw, err := NewWriterOptions(flat, WriterOptions{Level: 12, Framed: true})
+	if err != nil {
+		t.Fatalf("NewWriterOptions err=%q", err)
+	}
+	for _, c := range want {
+		fw.WriteFloats([]float64{real(c), imag(c)})
+	}
+	fw.Close()
+	if buf.Len() >= flat.Len() {
+		t.Errorf("complex stream is %d bytes, want less than %d for interleaved floats", buf.Len(), flat.Len())
+	}
+
+	r := NewComplexReader(bytes.NewReader(buf.Bytes()))
+	have := make([]complex128, len(want)+1)
+	n, err := r.ReadComplex128s(have)
+	if err != io.EOF || n != len(want) {
+		t.Fatalf("ReadComplex128s n=%d err=%v, want %d, io.EOF", n, err, len(want))
+	}
+	for i := range want {
+		if math.Float64bits(real(have[i])) != math.Float64bits(real(want[i])) ||
+			math.Float64bits(imag(have[i])) != math.Float64bits(imag(want[i])) {
+			t.Fatalf("value mismatch idx=%d have=%v want=%v", i, have[i], want[i])
+		}
+	}
+
+	// A MultiReader sees two channels.
+	if n, err := NewMultiReader(bytes.NewReader(buf.Bytes())).Channels(); err != nil || n != 2 {
+		t.Errorf("MultiReader Channels=%d, %v, want 2", n, err)
+	}
+}
+
+func TestComplex64(t *testing.T) {
+	spectrum := generateSpectrum(1001)
+	want := make([]complex64, len(spectrum))
+	for i, c := range spectrum {
+		want[i] = complex64(c)
+	}
+
+	buf := new(bytes.Buffer)
+	w, err := NewComplexWriter64(buf, 10)
+	if err != nil {
+		t.Fatalf("NewComplexWriter64 err=%q", err)
+	}
+	w.WriteComplex64s(want[:500])
+	w.Flush()
+	w.WriteComplex64s(want[500:])
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+
+	r := NewComplexReader64(buf)
+	have := make([]complex64, len(want))
+	if _, err := r.ReadComplex64s(have); err != nil {
+		t.Fatalf("ReadComplex64s err=%q", err)
+	}
+	for i := range want {
+		if have[i] != want[i] {
+			t.Fatalf("value mismatch idx=%d have=%v want=%v", i, have[i], want[i])
+		}
+	}
+	if _, err := r.ReadComplex64s(have); err != io.EOF {
+		t.Errorf("ReadComplex64s at end err=%v, want io.EOF", err)
+	}
+}
+
+func TestComplexReaderInvalid(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.WriteFloats([]float64{1, 2})
+	w.Close()
+	_, err := NewComplexReader(buf).ReadComplex128s(make([]complex128, 1))
+	if _, ok := err.(DataError); !ok {
+		t.Errorf("ReadComplex128s of a single-channel stream err=%v, want DataError", err)
+	}
+
+	// A two-channel stream which ends partway through a record.
+	buf.Reset()
+	mw, _ := NewMultiWriter(buf, 2, 4)
+	mw.w.WriteFloats([]float64{1, 2, 3})
+	mw.Close()
+	n, err := NewComplexReader(buf).ReadComplex128s(make([]complex128, 2))
+	if _, ok := err.(DataError); !ok || n != 1 {
+		t.Errorf("ReadComplex128s of a partial value n=%d err=%v, want 1, DataError", n, err)
+	}
+}
@@ -14,6 +14,27 @@ func decodeBlockHeader(b []byte) (nRecords, nBytes int) {
 	return int(nRecordsUint), int(nBytesUint)
 }
 
+func decodeSegmentHeader(b []byte) (nValues, nBytes int) {
+	return int(byteOrder.Uint32(b[0:4])), int(byteOrder.Uint32(b[4:8]))
+}
+
+// decodeIndex parses the contents of an index segment, as laid out by
+// encodeIndex. It returns the index entries, and the total number of values
+// in the stream.
+func decodeIndex(b []byte) (index []indexEntry, total int64, err error) {
+	if len(b) < 8+indexFooterSize || (len(b)-8-indexFooterSize)%16 != 0 {
+		return nil, 0, dataError("malformed segment index")
+	}
+	n := (len(b) - 8 - indexFooterSize) / 16
+	index = make([]indexEntry, n)
+	for i := range index {
+		index[i].offset = int64(byteOrder.Uint64(b[16*i:]))
+		index[i].first = int64(byteOrder.Uint64(b[16*i+8:]))
+	}
+	total = int64(byteOrder.Uint64(b[16*n:]))
+	return index, total, nil
+}
+
 func decodeHeaders(b byte) (h1, h2 header) {
 	h1 = header{
 		len:   (b & 0x70) >> 4,
@@ -32,6 +53,33 @@ func decodeHeaders(b byte) (h1, h2 header) {
 	return h1, h2
 }
 
+// decodeHeaders32 is like decodeHeaders, but for streams of 32-bit values,
+// where a residual can have any length from 0 to 4 bytes.
+func decodeHeaders32(b byte) (h1, h2 header) {
+	h1 = header{
+		len:   (b & 0x70) >> 4,
+		pType: predictorClass((b & 0x80) >> 7),
+	}
+	h2 = header{
+		len:   (b & 0x07),
+		pType: predictorClass((b & 0x08) >> 3),
+	}
+	return h1, h2
+}
+
+// decodeHeaderExtended decodes the 5-bit header of a record from an extended
+// stream of values which are width bytes long.
+func decodeHeaderExtended(b byte, width int) header {
+	h := header{
+		len:   b & 0x07,
+		pType: predictorClass((b & 0x18) >> 3),
+	}
+	if h.len >= 4 && width == 8 {
+		h.len += 1
+	}
+	return h
+}
+
 func decodeData(b []byte) (v uint64) {
 	// Decode b as a partial little-endian uint64
 	switch len(b) {
@@ -0,0 +1,170 @@
+package fpc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+)
+
+// A Dictionary holds the state of the FCM and DFCM predictors after they
+// have seen some representative data. Streams which start from a dictionary
+// don't have to warm their predictors up from empty tables, so short streams
+// compress much better. The writer and reader of a stream must use the same
+// dictionary; the stream's framed header records its ID, so that readers can
+// check.
+//
+// A Dictionary only affects the FCM and DFCM predictors, and only for
+// streams of float64 values, at the dictionary's compression level and with
+// its hash shifts. It is safe for concurrent use.
+type Dictionary struct {
+	id     uint32
+	level  int
+	shifts HashShifts
+	fcm    []uint64
+	dfcm   []uint64
+}
+
+// dictMagic starts a Dictionary's binary encoding.
+var dictMagic = []byte("FPCd")
+
+const dictVersion = 1
+
+// TrainDictionary makes a Dictionary for streams compressed at the given
+// level, by running the predictors over samples. Each sample is treated as
+// the start of a separate stream, so samples should resemble the streams
+// which will use the dictionary.
+func TrainDictionary(samples [][]float64, level int) (*Dictionary, error) {
+	return TrainDictionaryShifts(samples, level, HashShifts{})
+}
+
+// TrainDictionaryShifts is like TrainDictionary, but for streams whose
+// predictors use the given hash shifts.
+func TrainDictionaryShifts(samples [][]float64, level int, s HashShifts) (*Dictionary, error) {
+	if level < 1 || level > MaxCompression {
+		return nil, fmt.Errorf("fpc: invalid compression level: %d", level)
+	}
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	s = s.orDefault(Float64)
+	fp, dp := newPredictors(uint(level), Float64, s)
+	f, d := fp.(*fcm), dp.(*dfcm)
+	for _, sample := range samples {
+		// Start each sample's history afresh, as a stream would, but keep
+		// the tables.
+		f.lastHash = 0
+		d.lastHash, d.lastValue = 0, 0
+		for _, v := range sample {
+			f.update(math.Float64bits(v))
+			d.update(math.Float64bits(v))
+		}
+	}
+	dict := &Dictionary{level: level, shifts: s, fcm: f.table, dfcm: d.table}
+	dict.id = dict.computeID()
+	return dict, nil
+}
+
+// ID returns an identifier for the dictionary, derived from its contents.
+// It is never zero.
+func (d *Dictionary) ID() uint32 {
+	return d.id
+}
+
+// Level returns the compression level of streams which can use the
+// dictionary.
+func (d *Dictionary) Level() int {
+	return d.level
+}
+
+// HashShifts returns the hash shifts of streams which can use the
+// dictionary.
+func (d *Dictionary) HashShifts() HashShifts {
+	return d.shifts
+}
+
+// computeID returns a checksum of d's encoding, avoiding zero, which
+// headers use to mean that there is no dictionary.
+func (d *Dictionary) computeID() uint32 {
+	b, _ := d.MarshalBinary()
+	id := crc32.Checksum(b, crcTable)
+	if id == 0 {
+		id = 1
+	}
+	return id
+}
+
+// MarshalBinary encodes the dictionary, so that it can be stored and shared
+// between writers and readers. It implements encoding.BinaryMarshaler.
+func (d *Dictionary) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, len(dictMagic)+6+16*len(d.fcm))
+	b = append(b, dictMagic...)
+	b = append(b, dictVersion, byte(d.level))
+	b = append(b, d.shifts.FCMHistory, d.shifts.FCMValue, d.shifts.DFCMHistory, d.shifts.DFCMValue)
+	for _, v := range d.fcm {
+		b = appendUint64(b, v)
+	}
+	for _, v := range d.dfcm {
+		b = appendUint64(b, v)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes a dictionary encoded by MarshalBinary, replacing
+// d's contents. It implements encoding.BinaryUnmarshaler.
+func (d *Dictionary) UnmarshalBinary(b []byte) error {
+	n := len(dictMagic) + 6
+	if len(b) < n || !bytes.Equal(b[:len(dictMagic)], dictMagic) {
+		return errors.New("fpc: not an encoded dictionary")
+	}
+	if v := b[len(dictMagic)]; v != dictVersion {
+		return fmt.Errorf("fpc: unsupported dictionary version %d", v)
+	}
+	level := int(b[len(dictMagic)+1])
+	s := HashShifts{
+		FCMHistory:  b[n-4],
+		FCMValue:    b[n-3],
+		DFCMHistory: b[n-2],
+		DFCMValue:   b[n-1],
+	}
+	if level < 1 || level > MaxCompression || s.check() != nil {
+		return errors.New("fpc: invalid dictionary parameters")
+	}
+	size := 1 << uint(level)
+	if len(b) != n+16*size {
+		return errors.New("fpc: encoded dictionary has the wrong length")
+	}
+	tables := make([]uint64, 2*size)
+	for i := range tables {
+		tables[i] = byteOrder.Uint64(b[n+8*i:])
+	}
+	*d = Dictionary{level: level, shifts: s, fcm: tables[:size], dfcm: tables[size:]}
+	d.id = d.computeID()
+	return nil
+}
+
+// check returns an error unless d can be used for streams described by h.
+func (d *Dictionary) check(h Header) error {
+	if h.ElementType != Float64 {
+		return fmt.Errorf("fpc: dictionaries can't be used for %v values", h.ElementType)
+	}
+	if h.Level != d.level || h.hashShifts().orDefault(h.ElementType) != d.shifts {
+		return fmt.Errorf("fpc: dictionary %08x is for level %d with hash shifts %+v", d.id, d.level, d.shifts)
+	}
+	return nil
+}
+
+// load sets the tables of any FCM and DFCM predictors in ps to those of d.
+func (d *Dictionary) load(ps ...predictor) {
+	for _, p := range ps {
+		switch p := p.(type) {
+		case *fcm:
+			copy(p.table, d.fcm)
+		case *dfcm:
+			copy(p.table, d.dfcm)
+		case *channelPredictor:
+			d.load(p.ps...)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package fpc
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"testing"
+)
+
+// generateReadings returns a short series of sensor readings, starting at
+// offset.
+func generateReadings(offset, n int) []float64 {
+	vals := make([]float64, n)
+	for i := range vals {
+		vals[i] = math.Round(100*(20+5*math.Sin(float64(offset+i)/10))) / 100
+	}
+	return vals
+}
+
+func compressDict(t *testing.T, vals []float64, dict *Dictionary) []byte {
+	buf := new(bytes.Buffer)
+	var w *Writer
+	var err error
+	if dict != nil {
+		w, err = NewWriterDict(buf, dict)
+	} else {
+		w, err = NewWriterOptions(buf, WriterOptions{Level: 12, Framed: true})
+	}
+	if err != nil {
+		t.Fatalf("new writer err=%q", err)
+	}
+	if _, err := w.WriteFloats(vals); err != nil {
+		t.Fatalf("WriteFloats err=%q", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDictionary(t *testing.T) {
+	var samples [][]float64
+	for i := 0; i < 50; i++ {
+		samples = append(samples, generateReadings(64*i, 64))
+	}
+	dict, err := TrainDictionary(samples, 12)
+	if err != nil {
+		t.Fatalf("TrainDictionary err=%q", err)
+	}
+
+	want := generateReadings(100000, 64)
+	withDict := compressDict(t, want, dict)
+	without := compressDict(t, want, nil)
+	if len(withDict) >= len(without) {
+		t.Errorf("dictionary stream takes %d bytes, want less than %d", len(withDict), len(without))
+	}
+
+	// The reader needs the same dictionary, which can be shared in its
+	// binary encoding.
+	b, err := dict.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary err=%q", err)
+	}
+	shared := new(Dictionary)
+	if err := shared.UnmarshalBinary(b); err != nil {
+		t.Fatalf("UnmarshalBinary err=%q", err)
+	}
+	if shared.ID() != dict.ID() {
+		t.Errorf("dictionary ID changed in round trip: have=%08x want=%08x", shared.ID(), dict.ID())
+	}
+
+	r := NewReaderDict(bytes.NewReader(withDict), shared)
+	for pass := 0; pass < 2; pass++ {
+		have := make([]float64, len(want)+1)
+		n, err := r.ReadFloats(have)
+		if err != nil && err != io.EOF {
+			t.Fatalf("ReadFloats pass=%d err=%q", pass, err)
+		}
+		if n != len(want) {
+			t.Fatalf("ReadFloats pass=%d read %d values, want %d", pass, n, len(want))
+		}
+		for i := range want {
+			if have[i] != want[i] {
+				t.Fatalf("value mismatch pass=%d idx=%d have=%v want=%v", pass, i, have[i], want[i])
+			}
+		}
+		// A reset Reader starts from the dictionary again.
+		r.Reset(bytes.NewReader(withDict))
+	}
+
+	// The Reader can still read streams without a dictionary.
+	r.Reset(bytes.NewReader(without))
+	if _, err := r.ReadFloats(make([]float64, len(want))); err != nil {
+		t.Errorf("ReadFloats without dictionary err=%q", err)
+	}
+}
+
+func TestDictionaryMismatch(t *testing.T) {
+	dict, err := TrainDictionary([][]float64{generateReadings(0, 100)}, 8)
+	if err != nil {
+		t.Fatalf("TrainDictionary err=%q", err)
+	}
+	other, err := TrainDictionary([][]float64{generateReadings(1000, 100)}, 8)
+	if err != nil {
+		t.Fatalf("TrainDictionary err=%q", err)
+	}
+	if dict.ID() == other.ID() {
+		t.Fatalf("dictionaries of different samples have the same ID %08x", dict.ID())
+	}
+	data := compressDict(t, generateReadings(0, 10), dict)
+
+	for _, r := range []*Reader{
+		NewReader(bytes.NewReader(data)),
+		NewReaderDict(bytes.NewReader(data), other),
+	} {
+		if _, err := r.ReadFloats(make([]float64, 10)); err == nil {
+			t.Errorf("reading dictionary stream without its dictionary should fail")
+		}
+	}
+
+	// Writers must match the dictionary's parameters.
+	for _, opts := range []WriterOptions{
+		{Level: 9, Dictionary: dict},
+		{Level: 8, HashShifts: HashShifts{4, 40, 1, 32}, Dictionary: dict},
+	} {
+		if _, err := NewWriterOptions(new(bytes.Buffer), opts); err == nil {
+			t.Errorf("NewWriterOptions with mismatched dictionary should fail: %+v", opts)
+		}
+	}
+	if _, err := NewWriter32Options(new(bytes.Buffer), WriterOptions{Level: 8, Dictionary: dict}); err == nil {
+		t.Errorf("NewWriter32Options with dictionary should fail")
+	}
+	if _, err := NewParallelWriterOptions(new(bytes.Buffer), WriterOptions{Level: 8, Dictionary: dict}, 2); err == nil {
+		t.Errorf("NewParallelWriterOptions with dictionary should fail")
+	}
+	if _, err := NewWriterDict(new(bytes.Buffer), nil); err == nil {
+		t.Errorf("NewWriterDict with nil dictionary should fail")
+	}
+
+	b, _ := dict.MarshalBinary()
+	for i, in := range [][]byte{
+		nil,
+		b[:len(b)-1],
+		append([]byte("FPCx"), b[4:]...),
+	} {
+		if err := new(Dictionary).UnmarshalBinary(in); err == nil {
+			t.Errorf("UnmarshalBinary test=%d should fail", i)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package fpc
 
 import (
 	"encoding/binary"
+	"hash/crc32"
 	"io"
 	"math"
 )
@@ -9,10 +10,27 @@ import (
 const (
 	maxRecordsPerBlock = 32768
 	blockHeaderSize    = 6 // in bytes
+	segmentHeaderSize  = 8 // in bytes
+	indexFooterSize    = 8 // in bytes
+	checksumSize       = 4 // in bytes
+
+	// segmentedFlag is set in the stream's leading byte, alongside the
+	// compression level, when the stream is made of independent segments.
+	segmentedFlag = 0x80
+	// checksumFlag is set in the stream's leading byte when each block is
+	// followed by a CRC-32C checksum of its contents.
+	checksumFlag = 0x40
 )
 
 var byteOrder = binary.LittleEndian
 
+// crcTable is used to compute block checksums, using the Castagnoli
+// polynomial.
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// indexMagic marks the end of a stream's segment index.
+var indexMagic = []byte("FPCi")
+
 // pairHeader combines the headers for two values into a single byte
 type pairHeader struct {
 	h1 header
@@ -31,7 +49,8 @@ type header struct {
 }
 
 // the top bit is the predictor type bit. Bottom 3 bits encode the number of
-// leading zero bytes for the value.
+// leading zero bytes for the value. In extended streams, the predictor type
+// takes two bits, so the header takes five bits in all.
 func (h header) encode() byte {
 	if h.len > 4 {
 		return byte(h.pType)<<3 | byte(h.len-1)
@@ -45,14 +64,23 @@ type blockEncoder struct {
 
 	headers []byte
 	values  []byte
+	times   []byte // encoded timestamps, for timestamped streams
 
-	w   io.Writer // Destination for encoded bytes
-	enc *encoder  // Underlying machinery for encoding pairs of floats
+	w        io.Writer  // Destination for encoded bytes
+	enc      *encoder   // Underlying machinery for encoding pairs of floats
+	checksum bool       // Whether to follow each block with a checksum
+	extended bool       // Whether to write 5-bit record headers, for extended streams
+	quant    *quantizer // Rounds values before encoding, for lossy streams
+	xform    *transformer
+	stats    Stats // Counts of what has been encoded, for Writer.Stats
+	clock    timestampCoder
 
 	// Mutable state below
 	last     uint64 // last value received to encode
 	nRecords int    // Count of float64s received in this block
 	nBytes   int    // Count of bytes in this block
+	bits     uint32 // Record header bits waiting to fill a byte, in extended streams
+	nBits    uint   // Count of bits in bits
 }
 
 // type block struct {
@@ -60,17 +88,43 @@ type blockEncoder struct {
 // }
 
 func newBlockEncoder(w io.Writer, compression uint) *blockEncoder {
+	return newBlockEncoderWith(w, newEncoder(compression))
+}
+
+// newBlockEncoderHeader creates a blockEncoder for a stream described by h.
+func newBlockEncoderHeader(w io.Writer, h Header) (*blockEncoder, error) {
+	ps, err := newStreamPredictors(h)
+	if err != nil {
+		return nil, err
+	}
+	b := newBlockEncoderWith(w, newEncoderPredictors(h.ElementType, ps))
+	b.checksum = h.Checksums
+	b.extended = h.Extended
+	b.xform = newTransformer(h.Transform, h.ElementType)
+	return b, nil
+}
+
+// newBlockEncoderWith creates a blockEncoder which uses enc to encode pairs
+// of values.
+func newBlockEncoderWith(w io.Writer, enc *encoder) *blockEncoder {
 	return &blockEncoder{
 		headers:  make([]byte, 0, maxRecordsPerBlock),
 		values:   make([]byte, 0, maxRecordsPerBlock*8),
 		w:        w,
-		enc:      newEncoder(compression),
+		enc:      enc,
 		last:     0,
 		nRecords: 0,
 	}
 }
 
 func (b *blockEncoder) encode(v uint64) error {
+	if b.quant != nil {
+		v = b.quant.quantize(v)
+	}
+	if b.xform != nil {
+		v = b.xform.apply(v)
+	}
+	b.stats.Values++
 	// Encode values in pairs
 	if b.nRecords%2 == 0 {
 		b.last = v
@@ -79,9 +133,16 @@ func (b *blockEncoder) encode(v uint64) error {
 	}
 	header, data := b.enc.encode(b.last, v)
 	nBytes := 1 + len(data) // 1 for header
+	b.stats.record(header.h1)
+	b.stats.record(header.h2)
 
 	// Append data to the block
-	b.headers = append(b.headers, header.encode())
+	if b.extended {
+		b.appendExtended(header.h1)
+		b.appendExtended(header.h2)
+	} else {
+		b.headers = append(b.headers, header.encode())
+	}
 	b.values = append(b.values, data...)
 	b.nRecords += 1
 	b.nBytes += nBytes
@@ -96,26 +157,68 @@ func (b *blockEncoder) encode(v uint64) error {
 
 }
 
+// appendExtended adds the 5-bit header of a record in an extended stream to
+// the block. Headers are packed together, starting from the least significant
+// bit of each byte.
+func (b *blockEncoder) appendExtended(h header) {
+	b.bits |= uint32(h.encode()) << b.nBits
+	b.nBits += 5
+	for b.nBits >= 8 {
+		b.headers = append(b.headers, byte(b.bits))
+		b.bits >>= 8
+		b.nBits -= 8
+	}
+}
+
+// encodePoint encodes a value along with its timestamp, for timestamped
+// streams.
+func (b *blockEncoder) encodePoint(t int64, v uint64) error {
+	b.times = b.clock.append(b.times, t)
+	return b.encode(v)
+}
+
 func (b *blockEncoder) encodeFloat(f float64) error {
 	return b.encode(math.Float64bits(f))
 }
 
+// encodeFloats encodes each value in fs, returning the number encoded.
+func (b *blockEncoder) encodeFloats(fs []float64) (int, error) {
+	for i, f := range fs {
+		if err := b.encode(math.Float64bits(f)); err != nil {
+			return i, err
+		}
+	}
+	return len(fs), nil
+}
+
 func (b *blockEncoder) flush() error {
 	if b.nRecords == 0 {
 		return nil
 	}
 	if b.nRecords%2 == 1 {
-		// There's an extra record waiting for a partner. Add a dummy value by
-		// encoding a zero and adding it to data.
-		h, data := b.enc.encode(b.last, 0)
-		// Truncate out the dummy value's data. The header remains, but it
-		// won't do any harm.
-		data = data[:h.h1.len]
-		b.headers = append(b.headers, h.encode())
+		// There's an extra record waiting for a partner, so it's encoded
+		// alone.
+		h, data := b.enc.encodeOne(b.last)
+		b.stats.record(h.h1)
+		if b.extended {
+			b.appendExtended(h.h1)
+		} else {
+			b.headers = append(b.headers, h.encode())
+		}
 		b.values = append(b.values, data...)
 	}
+	if b.nBits > 0 {
+		// Pad out the last byte of record headers.
+		b.headers = append(b.headers, byte(b.bits))
+		b.bits, b.nBits = 0, 0
+	}
 
 	block := b.encodeBlock()
+	if b.checksum {
+		var crc [checksumSize]byte
+		byteOrder.PutUint32(crc[:], crc32.Checksum(block, crcTable))
+		block = append(block, crc[:]...)
+	}
 	// Write data out
 	n, err := b.w.Write(block)
 	if err != nil {
@@ -124,20 +227,40 @@ func (b *blockEncoder) flush() error {
 	if n < len(block) {
 		return io.ErrShortWrite
 	}
+	b.stats.Blocks++
+	b.stats.CompressedBytes += int64(n)
 
 	// Reset buffer and counters
-	b.headers = make([]byte, 0, maxRecordsPerBlock)
-	b.values = make([]byte, 0, maxRecordsPerBlock)
+	b.headers = b.headers[:0]
+	b.values = b.values[:0]
+	b.times = b.times[:0]
 	b.nRecords = 0
 	b.nBytes = 0
 	return nil
 }
 
+// reset discards any buffered values and clears the predictors, so that b
+// can encode a new stream to w.
+func (b *blockEncoder) reset(w io.Writer) {
+	b.w = w
+	b.enc.reset()
+	b.headers = b.headers[:0]
+	b.values = b.values[:0]
+	b.times = b.times[:0]
+	b.clock = timestampCoder{}
+	b.xform.reset()
+	b.last = 0
+	b.nRecords = 0
+	b.nBytes = 0
+	b.bits, b.nBits = 0, 0
+	b.stats = Stats{}
+}
+
 func (b *blockEncoder) encodeBlock() []byte {
 	// The block header is layed out as two little-endian 24-bit unsigned
 	// integers. The first integer is the number of records in the block, and
 	// the second is the number of bytes.
-	nByte := len(b.headers) + len(b.values) + blockHeaderSize
+	nByte := len(b.headers) + len(b.values) + len(b.times) + blockHeaderSize
 	block := make([]byte, 6, nByte)
 
 	//First three bytes are the number of records in the block.
@@ -153,25 +276,146 @@ func (b *blockEncoder) encodeBlock() []byte {
 	// Record headers follow the block header
 	block = append(block, b.headers...)
 
-	// After the header is all the rest of the data.
+	// After the header is all the rest of the data, and then the
+	// timestamps, if there are any.
 	block = append(block, b.values...)
+	block = append(block, b.times...)
 	return block
 }
 
+// A timestampCoder encodes and decodes the timestamps of a timestamped
+// stream, as the difference between each timestamp's delta from the previous
+// one and the previous delta. Regularly spaced timestamps encode as zeros.
+type timestampCoder struct {
+	prev  int64 // previous timestamp
+	delta int64 // previous delta between timestamps
+}
+
+// append encodes t as a zig-zag varint, appending it to b.
+func (c *timestampCoder) append(b []byte, t int64) []byte {
+	delta := t - c.prev
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], delta-c.delta)
+	c.prev, c.delta = t, delta
+	return append(b, buf[:n]...)
+}
+
+// decode decodes len(ts) timestamps from the start of b into ts, and returns
+// the rest of b.
+func (c *timestampCoder) decode(b []byte, ts []int64) ([]byte, error) {
+	for i := range ts {
+		dod, n := binary.Varint(b)
+		if n <= 0 {
+			return nil, dataError("missing timestamps")
+		}
+		b = b[n:]
+		c.delta += dod
+		c.prev += c.delta
+		ts[i] = c.prev
+	}
+	return b, nil
+}
+
+// encodeSegmentHeader lays out a segment header as two little-endian 32-bit
+// unsigned integers. The first is the number of values in the segment, and
+// the second is the number of bytes which follow the header.
+func encodeSegmentHeader(nValues, nBytes int) []byte {
+	b := make([]byte, segmentHeaderSize)
+	byteOrder.PutUint32(b[0:4], uint32(nValues))
+	byteOrder.PutUint32(b[4:8], uint32(nBytes))
+	return b
+}
+
+// An indexEntry locates a segment within a stream.
+type indexEntry struct {
+	offset int64 // byte offset of the segment's header
+	first  int64 // ordinal of the segment's first value
+}
+
+// encodeIndex lays out an index of a stream's segments as a segment holding
+// no values. Its contents are a pair of little-endian 64-bit integers for each
+// entry, followed by the total number of values in the stream. It ends with a
+// footer holding the size of the contents, so that it can be found by
+// reading backwards from the end of the stream.
+func encodeIndex(index []indexEntry, total int64) []byte {
+	nBytes := 16*len(index) + 8 + indexFooterSize
+	b := make([]byte, segmentHeaderSize, segmentHeaderSize+nBytes)
+	copy(b, encodeSegmentHeader(0, nBytes))
+	for _, e := range index {
+		b = appendUint64(b, uint64(e.offset))
+		b = appendUint64(b, uint64(e.first))
+	}
+	b = appendUint64(b, uint64(total))
+
+	footer := make([]byte, indexFooterSize)
+	byteOrder.PutUint32(footer[0:4], uint32(nBytes))
+	copy(footer[4:], indexMagic)
+	return append(b, footer...)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	byteOrder.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
 type encoder struct {
-	buf []byte
+	buf   []byte
+	width uint8 // size of each value in bytes
 
 	// predictors
-	fcm  predictor
-	dfcm predictor
+	fcm   predictor
+	dfcm  predictor
+	extra []predictor // further predictors, used by extended streams
+
+	dict *Dictionary // state which the predictors start from, if any
 }
 
 func newEncoder(compression uint) *encoder {
-	tableSize := uint(1 << compression)
-	return &encoder{
-		buf:  make([]byte, 17),
-		fcm:  newFCM(tableSize),
-		dfcm: newDFCM(tableSize),
+	return newEncoderType(compression, Float64)
+}
+
+// newEncoderType creates an encoder for values of type t.
+func newEncoderType(compression uint, t ElementType) *encoder {
+	fcm, dfcm := newPredictors(compression, t, HashShifts{})
+	return newEncoderPredictors(t, []predictor{fcm, dfcm})
+}
+
+// newEncoderPredictors creates an encoder for values of type t which uses
+// the given predictors. There must be two of them, or four for extended
+// streams.
+func newEncoderPredictors(t ElementType, ps []predictor) *encoder {
+	e := &encoder{
+		buf:   make([]byte, 17),
+		width: uint8(t.size()),
+		fcm:   ps[0],
+		dfcm:  ps[1],
+	}
+	if len(ps) > 2 {
+		e.extra = ps[2:]
+	}
+	return e
+}
+
+// reset returns the encoder's predictors to their initial state: empty, or
+// loaded from the encoder's dictionary.
+func (e *encoder) reset() {
+	e.fcm.reset()
+	e.dfcm.reset()
+	for _, p := range e.extra {
+		p.reset()
+	}
+	if e.dict != nil {
+		e.dict.load(e.fcm, e.dfcm)
+	}
+}
+
+// setDictionary makes the encoder's predictors start from d, if it isn't
+// nil.
+func (e *encoder) setDictionary(d *Dictionary) {
+	e.dict = d
+	if d != nil {
+		d.load(e.fcm, e.dfcm)
 	}
 }
 
@@ -179,12 +423,19 @@ func newEncoder(compression uint) *encoder {
 // difference and which predictor was the most effective. Updates predictors as
 // a side effect.
 func (e *encoder) computeDiff(v uint64) (d uint64, h header) {
-	fcmDelta := e.fcm.predict() ^ v
+	d, h = e.predictDiff(v)
 	e.fcm.update(v)
-
-	dfcmDelta := e.dfcm.predict() ^ v
 	e.dfcm.update(v)
+	for _, p := range e.extra {
+		p.update(v)
+	}
+	return d, h
+}
 
+// predictDiff is like computeDiff, but leaves the predictors alone.
+func (e *encoder) predictDiff(v uint64) (d uint64, h header) {
+	fcmDelta := e.fcm.predict() ^ v
+	dfcmDelta := e.dfcm.predict() ^ v
 	if fcmDelta <= dfcmDelta {
 		d = fcmDelta
 		h.pType = fcmPredictor
@@ -192,6 +443,13 @@ func (e *encoder) computeDiff(v uint64) (d uint64, h header) {
 		d = dfcmDelta
 		h.pType = dfcmPredictor
 	}
+	for i, p := range e.extra {
+		delta := p.predict() ^ v
+		if delta < d {
+			d = delta
+			h.pType = predictorClass(2 + i)
+		}
+	}
 	h.len = uint8(8 - clzBytes(d))
 
 	//   "Since there can be between zero and eight leading zero bytes, i.e.,
@@ -203,7 +461,10 @@ func (e *encoder) computeDiff(v uint64) (d uint64, h header) {
 	//   residual."
 	//
 	// Here we add 1, to include one of the leading 0s in the residual.
-	if h.len == 4 {
+	//
+	// 32-bit values have at most four nonzero bytes, so they never need
+	// this adjustment.
+	if h.len == 4 && e.width == 8 {
 		h.len += 1
 	}
 	return d, h
@@ -221,6 +482,18 @@ func (e *encoder) encode(v1, v2 uint64) (h pairHeader, data []byte) {
 	return h, e.buf[:h1.len+h2.len]
 }
 
+// encodeOne encodes a single value, for the last record of a block with an
+// odd number of records. Its header is paired with that of a dummy zero
+// value, whose data is left out. The dummy is predicted without updating
+// the predictors, since readers never decode it; its header matches the one
+// written by the reference implementation.
+func (e *encoder) encodeOne(v uint64) (h pairHeader, data []byte) {
+	d, h1 := e.computeDiff(v)
+	_, h2 := e.predictDiff(0)
+	e.encodeNonzero(d, h1.len, e.buf[:h1.len])
+	return pairHeader{h1, h2}, e.buf[:h1.len]
+}
+
 func (e *encoder) encodeNonzero(v uint64, n uint8, into []byte) {
 	// Starting with the first nonzero byte, copy v's data into the byte slice.
 	//
@@ -256,6 +529,11 @@ func (e *encoder) encodeNonzero(v uint64, n uint8, into []byte) {
 		into[2] = byte((v >> 16) & 0xFF)
 		into[3] = byte((v >> 24) & 0xFF)
 		into[4] = byte((v >> 32) & 0xFF)
+	case 4:
+		into[0] = byte(v & 0xFF)
+		into[1] = byte((v >> 8) & 0xFF)
+		into[2] = byte((v >> 16) & 0xFF)
+		into[3] = byte((v >> 24) & 0xFF)
 	case 3:
 		into[0] = byte(v & 0xFF)
 		into[1] = byte((v >> 8) & 0xFF)
@@ -214,3 +214,4 @@ type mockPredictor struct {
 
 func (p *mockPredictor) predict() uint64 { return p.val }
 func (p *mockPredictor) update(uint64)   {}
+func (p *mockPredictor) reset()          {}
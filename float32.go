@@ -0,0 +1,185 @@
+package fpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// A Writer32 is an io.WriteCloser which FPC-compresses IEEE 754 32-bit
+// floating point values it receives and writes them to an underlying writer.
+//
+// Its predictors hash on the bits of 32-bit values, so it compresses float32
+// data better than widening it to float64 for a Writer would. Streams
+// written by a Writer32 always have a framed header, which records that they
+// hold float32 values, and they can only be read with a Reader32.
+type Writer32 struct {
+	w *Writer
+}
+
+// NewWriter32 makes a new Writer32 which writes compressed data to w
+// using a provided compression level. It returns an error if an invalid
+// compression level is provided.
+func NewWriter32(w io.Writer, level int) (*Writer32, error) {
+	return NewWriter32Options(w, WriterOptions{Level: level})
+}
+
+// NewWriter32Options makes a new Writer32 which writes compressed data to w
+// in the format described by opts. A framed header is always written,
+// whether or not opts.Framed is set. It returns an error if opts are invalid.
+func NewWriter32Options(w io.Writer, opts WriterOptions) (*Writer32, error) {
+	opts.Framed = true
+	z, err := newWriter(w, opts, Float32)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer32{w: z}, nil
+}
+
+// Write interprets b as a stream of byte-encoded, 32-bit IEEE 754
+// floating point values. The length of b must be a multiple of 4 in
+// order to match this expectation.
+func (w *Writer32) Write(b []byte) (int, error) {
+	if len(b)%4 != 0 {
+		return 0, errors.New("fpc.Write: len of data must be a multiple of 4")
+	}
+	for i := 0; i < len(b); i += 4 {
+		if err := w.w.writeUint64(uint64(binary.LittleEndian.Uint32(b[i : i+4]))); err != nil {
+			return i, err
+		}
+	}
+	return len(b), nil
+}
+
+// WriteFloat32 writes a single float32 value to the encoded stream.
+func (w *Writer32) WriteFloat32(f float32) error {
+	return w.w.writeUint64(uint64(math.Float32bits(f)))
+}
+
+// WriteFloat32s writes a slice of float32 values to the encoded stream. It
+// returns the number of values written.
+func (w *Writer32) WriteFloat32s(fs []float32) (int, error) {
+	if err := w.w.ensureHeader(); err != nil {
+		return 0, err
+	}
+	for i, f := range fs {
+		if err := w.w.enc.encode(uint64(math.Float32bits(f))); err != nil {
+			return i, err
+		}
+	}
+	return len(fs), nil
+}
+
+// Flush will make sure all internally-buffered values are written to
+// the underlying io.Writer, even if it results in a partial block. It
+// does not flush the underlying io.Writer.
+func (w *Writer32) Flush() error {
+	return w.w.Flush()
+}
+
+// Reset discards the Writer32's state and makes it equivalent to the result
+// of its original constructor, but writing to dst instead, reusing its
+// predictor tables.
+func (w *Writer32) Reset(dst io.Writer) {
+	w.w.Reset(dst)
+}
+
+// Stats reports what the Writer32 has compressed since it was created or
+// last reset.
+func (w *Writer32) Stats() Stats {
+	return w.w.Stats()
+}
+
+// Close will flush the Writer32 and make any subsequent writes return
+// errors. It does not close the underlying io.Writer.
+func (w *Writer32) Close() error {
+	return w.w.Close()
+}
+
+// A Reader32 provides io.Reader-style access to a stream of FPC compressed
+// IEEE 754 32-bit floating point values, as written by a Writer32.
+type Reader32 struct {
+	r *Reader
+}
+
+// NewReader32 creates a new Reader32 which reads and decompresses FPC data
+// from the given io.Reader.
+func NewReader32(r io.Reader) *Reader32 {
+	return &Reader32{
+		r: &Reader{r: r, elem: Float32},
+	}
+}
+
+// Read reads from up to (len(buf) / 4) IEEE 754 32-bit floating point
+// values into buf. It is an error to provide a buf whose length is
+// not a multiple of 4, because that would prevent encoding of the
+// read float32s.
+//
+// If more values might be available, Read will return len(buf),
+// nil. If no more values are available, Read will return with
+// err==io.EOF
+func (r *Reader32) Read(buf []byte) (int, error) {
+	if len(buf)%4 != 0 {
+		return 0, errors.New("fpc: []byte passed to Reader32.Read must have length which is a multiple of 4")
+	}
+	return r.r.read(buf)
+}
+
+// ReadFloat32s will read data from the underlying io.Reader, parsing
+// the data it gets back as float32s and putting them into fs. If no
+// more values are available, ReadFloat32s will returns with an
+// err==io.EOF.
+func (r *Reader32) ReadFloat32s(fs []float32) (int, error) {
+	if !r.r.initialized {
+		// Make sure the stream holds float32s before decoding any of it.
+		if err := r.r.initialize(); err != nil {
+			return 0, err
+		}
+	}
+	nRead := 0
+	for nRead < len(fs) {
+		vals, err := r.r.values(len(fs) - nRead)
+		if err != nil {
+			return nRead, err
+		}
+		for i, v := range vals {
+			fs[nRead+i] = math.Float32frombits(uint32(v))
+		}
+		nRead += len(vals)
+	}
+	return nRead, nil
+}
+
+// ReadFloat32 will read data from the underlying io.Reader until it has
+// read enough data to provide a float32, decodes that data, and
+// returns the decoded float32. If an error is encountered while
+// reading, it returns 0 and that error. If no more values are
+// available, ReadFloat32 will return with err==io.EOF.
+func (r *Reader32) ReadFloat32() (float32, error) {
+	fs := make([]float32, 1)
+	_, err := r.ReadFloat32s(fs)
+	if err != nil {
+		return 0, err
+	}
+	return fs[0], nil
+}
+
+// Header returns the header describing the format of the stream, reading it
+// from the underlying io.Reader if necessary.
+func (r *Reader32) Header() (Header, error) {
+	return r.r.Header()
+}
+
+// Reset discards the Reader32's state and makes it equivalent to the result
+// of its original constructor, but reading from src instead, reusing its
+// predictor tables where possible.
+func (r *Reader32) Reset(src io.Reader) {
+	r.r.Reset(src)
+}
+
+// Stats reports what the Reader32 has decoded since it was created or last
+// reset.
+func (r *Reader32) Stats() Stats {
+	return r.r.Stats()
+}
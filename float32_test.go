@@ -0,0 +1,95 @@
+package fpc
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"testing"
+)
+
+func generateFloat32s(n int) []float32 {
+	vals := make([]float32, n)
+	for i := range vals {
+		vals[i] = float32(math.Sin(float64(i)/1000) * float64(i%97))
+	}
+	return vals
+}
+
+func TestWriter32RoundTrip(t *testing.T) {
+	testcases := [][]float32{
+		{},
+		{1},
+		{1, 1, 0.9, 0.9},
+		{0, float32(math.Copysign(0, -1)), float32(math.Inf(1)), float32(math.Inf(-1)), math.Float32frombits(0x7FC00001), math.MaxFloat32, math.SmallestNonzeroFloat32},
+		generateFloat32s(2*maxRecordsPerBlock + 1),
+	}
+	for i, want := range testcases {
+		buf := new(bytes.Buffer)
+		w, err := NewWriter32(buf, DefaultCompression)
+		if err != nil {
+			t.Fatalf("NewWriter32 err=%q", err)
+		}
+		if _, err := w.WriteFloat32s(want); err != nil {
+			t.Fatalf("WriteFloat32s test=%d err=%q", i, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close test=%d err=%q", i, err)
+		}
+
+		r := NewReader32(buf)
+		h, err := r.Header()
+		if err != nil {
+			t.Fatalf("Header test=%d err=%q", i, err)
+		}
+		if h.ElementType != Float32 {
+			t.Errorf("Header test=%d ElementType=%v, want float32", i, h.ElementType)
+		}
+		have := make([]float32, len(want)+1)
+		n, err := r.ReadFloat32s(have)
+		if err != io.EOF {
+			t.Errorf("ReadFloat32s test=%d err=%v, want io.EOF", i, err)
+		}
+		if n != len(want) {
+			t.Fatalf("ReadFloat32s test=%d n=%d, want %d", i, n, len(want))
+		}
+		for j := range want {
+			if math.Float32bits(have[j]) != math.Float32bits(want[j]) {
+				t.Fatalf("value mismatch test=%d idx=%d have=%v want=%v", i, j, have[j], want[j])
+			}
+		}
+	}
+}
+
+func TestWriter32Smaller(t *testing.T) {
+	vals := generateFloat32s(100000)
+
+	buf32 := new(bytes.Buffer)
+	w32, _ := NewWriter32(buf32, DefaultCompression)
+	w32.WriteFloat32s(vals)
+	w32.Close()
+
+	buf64 := new(bytes.Buffer)
+	w64 := NewWriter(buf64)
+	for _, f := range vals {
+		w64.WriteFloat(float64(f))
+	}
+	w64.Close()
+
+	if buf32.Len() >= buf64.Len() {
+		t.Errorf("float32 stream is %d bytes, not smaller than widened float64 stream of %d bytes", buf32.Len(), buf64.Len())
+	}
+}
+
+func TestReaderElementTypeMismatch(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w, _ := NewWriter32(buf, DefaultCompression)
+	w.WriteFloat32(1)
+	w.Close()
+	if _, err := NewReader(bytes.NewReader(buf.Bytes())).ReadFloat(); err == nil {
+		t.Errorf("Reader should refuse a float32 stream")
+	}
+
+	if _, err := NewReader32(bytes.NewReader(refTests[4].compressed)).ReadFloat32(); err == nil {
+		t.Errorf("Reader32 should refuse a float64 stream")
+	}
+}
@@ -0,0 +1,448 @@
+package fpc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// FPC streams begin with a header describing how they were encoded. There are
+// two kinds of header.
+//
+// The raw header is the one used by the reference implementation: a single
+// byte holding the compression level. This package also uses the top two bits
+// of that byte as flags for segmented streams and checksummed blocks, which
+// the reference implementation doesn't support.
+//
+// The framed header is self-describing. It starts with magic bytes, followed
+// by a version byte, two little-endian bytes of flags, an element type byte,
+// and a compression level byte. The first magic byte can't be mistaken for a
+// valid raw header, so readers can tell the two apart. If the stream uses
+// predictors other than the defaults, their names follow as a count byte and
+// then a length byte and the bytes of each name. If the stream's FCM and DFCM
+// predictors use hash shifts other than the defaults, four bytes holding them
+// follow. If the stream's values are rotated, a byte holding the rotation
+// comes next. If the stream has several channels, two little-endian bytes
+// holding the number of channels follow. If the stream's values are
+// transformed, a byte holding the Transform comes next, and if the stream
+// starts from a Dictionary, four little-endian bytes holding its ID come last.
+//
+// Only framed headers can mark a stream as extended. In extended streams,
+// each record header takes 5 bits, rather than 4: 3 bits for the number of
+// bytes in the record, as usual, and then 2 bits to select one of four
+// predictors. The headers are packed together, starting from the least
+// significant bit of each byte.
+//
+// Likewise, only framed headers can mark a stream as timestamped. In
+// timestamped streams, each block ends with a timestamp for each of its
+// records, after the records' data. Each timestamp is stored as the
+// difference between its delta from the previous timestamp and the previous
+// delta, as a zig-zag varint. Like the predictors, the previous timestamp
+// and delta carry over from one block to the next, and start at zero.
+
+// framedMagic identifies a stream with a framed header.
+var framedMagic = []byte{0xFF, 'F', 'P', 'C'}
+
+const (
+	// FramedVersion is the version of the framed header written by this
+	// package.
+	FramedVersion = 1
+
+	framedHeaderSize = 9 // in bytes
+)
+
+// Flags in a framed header.
+const (
+	framedChecksums uint16 = 1 << iota
+	framedSegmented
+	framedIndexed
+	framedPredictors
+	framedExtended
+	framedHashShifts
+	framedRotation
+	framedChannels
+	framedTimestamps
+	framedTransform
+	framedDictionary
+
+	framedKnownFlags = framedChecksums | framedSegmented | framedIndexed |
+		framedPredictors | framedExtended | framedHashShifts | framedRotation |
+		framedChannels | framedTimestamps | framedTransform | framedDictionary
+)
+
+// An ElementType describes the kind of value held in a stream.
+type ElementType uint8
+
+const (
+	Float64 ElementType = iota // IEEE 754 64-bit floating point values
+	Float32                    // IEEE 754 32-bit floating point values
+)
+
+func (t ElementType) String() string {
+	switch t {
+	case Float64:
+		return "float64"
+	case Float32:
+		return "float32"
+	default:
+		return fmt.Sprintf("ElementType(%d)", uint8(t))
+	}
+}
+
+// size returns the number of bytes in each value of type t, or zero if t is
+// unknown.
+func (t ElementType) size() int {
+	switch t {
+	case Float64:
+		return 8
+	case Float32:
+		return 4
+	default:
+		return 0
+	}
+}
+
+// A Header describes the format of an FPC stream, as recorded at its start.
+type Header struct {
+	Framed  bool // Whether the stream has a framed header, rather than a raw one
+	Version int  // Version of the framed header; zero for raw headers
+
+	Level       int         // Compression level
+	ElementType ElementType // Kind of values in the stream
+
+	Checksums bool // Whether each block is followed by a checksum
+	Segmented bool // Whether the stream is made of independent segments
+	Indexed   bool // Whether the stream ends with an index of its segments
+
+	// Extended is set for streams whose records choose among four
+	// predictors, rather than two. Only framed headers can record it.
+	Extended bool
+
+	// Predictors names the predictors used by the stream, if they aren't
+	// the defaults. Only framed headers can record them.
+	Predictors []string
+
+	// HashShifts configure the FCM and DFCM predictors' hash functions, if
+	// they aren't the defaults. Only framed headers can record them.
+	HashShifts HashShifts
+
+	// Rotation is the number of bits by which each value was rotated right
+	// before being compressed, which is done by lossy writers. Only framed
+	// headers can record it.
+	Rotation uint8
+
+	// Channels is the number of interleaved channels in a stream written
+	// by a MultiWriter, each with its own predictors. It is zero for
+	// streams with a single channel. Only framed headers can record it.
+	Channels int
+
+	// Timestamps is set for streams written by a SeriesWriter, whose
+	// blocks hold a timestamp for each value. Only framed headers can
+	// record it.
+	Timestamps bool
+
+	// Transform is applied to each value before it is compressed. Only
+	// framed headers can record it.
+	Transform Transform
+
+	// DictionaryID identifies the Dictionary which the stream's predictors
+	// start from, or is zero if they start empty. Only framed headers can
+	// record it.
+	DictionaryID uint32
+}
+
+// ReadHeader reads and parses the header at the start of an FPC stream. It
+// returns io.EOF if r is empty, and a DataError if r doesn't hold an FPC
+// stream which this package can read.
+func ReadHeader(r io.Reader) (Header, error) {
+	b := make([]byte, framedHeaderSize)
+	if _, err := io.ReadFull(r, b[:1]); err != nil {
+		return Header{}, err
+	}
+	if b[0] != framedMagic[0] {
+		return decodeRawHeader(b[0]), nil
+	}
+
+	if _, err := io.ReadFull(r, b[1:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return Header{}, dataError("framed header too short")
+		}
+		return Header{}, err
+	}
+	h, err := decodeFramedHeader(b)
+	if err != nil {
+		return h, err
+	}
+
+	// Optional fields follow the fixed part of the header.
+	flags := byteOrder.Uint16(b[5:7])
+	if flags&framedPredictors != 0 {
+		if h.Predictors, err = readPredictorNames(r); err != nil {
+			return h, err
+		}
+	}
+	if flags&framedHashShifts != 0 {
+		if h.HashShifts, err = readHashShifts(r); err != nil {
+			return h, err
+		}
+	}
+	if flags&framedRotation != 0 {
+		b := make([]byte, 1)
+		if err = readHeaderField(r, b); err != nil {
+			return h, err
+		}
+		h.Rotation = b[0]
+		if h.Rotation == 0 || int(h.Rotation) >= 8*h.ElementType.size() {
+			return h, dataError("invalid rotation")
+		}
+	}
+	if flags&framedChannels != 0 {
+		b := make([]byte, 2)
+		if err = readHeaderField(r, b); err != nil {
+			return h, err
+		}
+		h.Channels = int(byteOrder.Uint16(b))
+		if h.Channels < 2 {
+			return h, dataError("invalid channel count")
+		}
+	}
+	if flags&framedTransform != 0 {
+		b := make([]byte, 1)
+		if err = readHeaderField(r, b); err != nil {
+			return h, err
+		}
+		h.Transform = Transform(b[0])
+		if h.Transform == NoTransform || !h.Transform.valid() {
+			return h, dataErrorf("unsupported transform %v", h.Transform)
+		}
+	}
+	if flags&framedDictionary != 0 {
+		b := make([]byte, 4)
+		if err = readHeaderField(r, b); err != nil {
+			return h, err
+		}
+		h.DictionaryID = byteOrder.Uint32(b)
+		if h.DictionaryID == 0 {
+			return h, dataError("invalid dictionary ID")
+		}
+	}
+	return h, nil
+}
+
+// readHeaderField fills b from r, which holds the rest of a framed header.
+func readHeaderField(r io.Reader, b []byte) error {
+	_, err := io.ReadFull(r, b)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return dataError("framed header too short")
+	}
+	return err
+}
+
+// readPredictorNames reads the list of predictor names which follows a
+// framed header.
+func readPredictorNames(r io.Reader) ([]string, error) {
+	b := make([]byte, 255)
+	read := func(b []byte) error {
+		return readHeaderField(r, b)
+	}
+	if err := read(b[:1]); err != nil {
+		return nil, err
+	}
+	if b[0] == 0 {
+		return nil, dataError("framed header names no predictors")
+	}
+	names := make([]string, b[0])
+	for i := range names {
+		if err := read(b[:1]); err != nil {
+			return nil, err
+		}
+		n := b[0]
+		if err := read(b[:n]); err != nil {
+			return nil, err
+		}
+		names[i] = string(b[:n])
+	}
+	return names, nil
+}
+
+// readHashShifts reads the hash shifts which follow a framed header.
+func readHashShifts(r io.Reader) (HashShifts, error) {
+	b := make([]byte, 4)
+	if err := readHeaderField(r, b); err != nil {
+		return HashShifts{}, err
+	}
+	s := HashShifts{FCMHistory: b[0], FCMValue: b[1], DFCMHistory: b[2], DFCMValue: b[3]}
+	if s == (HashShifts{}) || s.check() != nil {
+		return HashShifts{}, dataError("invalid hash shifts")
+	}
+	return s, nil
+}
+
+// IsFramed reports whether b begins with the magic bytes which identify an
+// FPC stream with a framed header.
+func IsFramed(b []byte) bool {
+	return bytes.HasPrefix(b, framedMagic)
+}
+
+func decodeRawHeader(b byte) Header {
+	return Header{
+		Level:       int(b &^ (segmentedFlag | checksumFlag)),
+		ElementType: Float64,
+		Checksums:   b&checksumFlag != 0,
+		Segmented:   b&segmentedFlag != 0,
+		// Segmented streams are written by ParallelWriter, which always
+		// ends them with an index.
+		Indexed: b&segmentedFlag != 0,
+	}
+}
+
+func decodeFramedHeader(b []byte) (Header, error) {
+	if !IsFramed(b) {
+		return Header{}, dataError("missing framed header magic")
+	}
+	h := Header{
+		Framed:      true,
+		Version:     int(b[4]),
+		ElementType: ElementType(b[7]),
+		Level:       int(b[8]),
+	}
+	if h.Version != FramedVersion {
+		return h, dataErrorf("unsupported framed header version %d", h.Version)
+	}
+	flags := byteOrder.Uint16(b[5:7])
+	if flags&^framedKnownFlags != 0 {
+		return h, dataErrorf("unsupported framed header flags %#x", flags)
+	}
+	if h.ElementType.size() == 0 {
+		return h, dataErrorf("unsupported element type %v", h.ElementType)
+	}
+	h.Checksums = flags&framedChecksums != 0
+	h.Segmented = flags&framedSegmented != 0
+	h.Indexed = flags&framedIndexed != 0
+	h.Extended = flags&framedExtended != 0
+	h.Timestamps = flags&framedTimestamps != 0
+	return h, nil
+}
+
+// hashShifts returns the hash shifts used by the stream's FCM and DFCM
+// predictors. Unless the header records shifts, these are the defaults,
+// adjusted so that they hash the same bits of rotated values as of unrotated
+// ones.
+func (h Header) hashShifts() HashShifts {
+	if h.HashShifts != (HashShifts{}) || h.Rotation == 0 {
+		return h.HashShifts
+	}
+	s := defaultHashShifts(h.ElementType)
+	sub := func(shift uint8) uint8 {
+		if shift < h.Rotation {
+			return 0
+		}
+		return shift - h.Rotation
+	}
+	s.FCMValue, s.DFCMValue = sub(s.FCMValue), sub(s.DFCMValue)
+	return s
+}
+
+// channels returns the number of channels in the stream, which is at least
+// one.
+func (h Header) channels() int {
+	if h.Channels < 1 {
+		return 1
+	}
+	return h.Channels
+}
+
+// samePredictors reports whether streams described by h1 and h2 use the same
+// predictors.
+func samePredictors(h1, h2 Header) bool {
+	names1, names2 := predictorNames(h1), predictorNames(h2)
+	if len(names1) != len(names2) {
+		return false
+	}
+	for i := range names1 {
+		if names1[i] != names2[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// encode lays out h as it should appear at the start of a stream.
+func (h Header) encode() []byte {
+	if !h.Framed {
+		b := byte(h.Level)
+		if h.Checksums {
+			b |= checksumFlag
+		}
+		if h.Segmented {
+			b |= segmentedFlag
+		}
+		return []byte{b}
+	}
+
+	var flags uint16
+	if h.Checksums {
+		flags |= framedChecksums
+	}
+	if h.Segmented {
+		flags |= framedSegmented
+	}
+	if h.Indexed {
+		flags |= framedIndexed
+	}
+	if len(h.Predictors) > 0 {
+		flags |= framedPredictors
+	}
+	if h.Extended {
+		flags |= framedExtended
+	}
+	if h.HashShifts != (HashShifts{}) {
+		flags |= framedHashShifts
+	}
+	if h.Rotation != 0 {
+		flags |= framedRotation
+	}
+	if h.Channels > 1 {
+		flags |= framedChannels
+	}
+	if h.Timestamps {
+		flags |= framedTimestamps
+	}
+	if h.Transform != NoTransform {
+		flags |= framedTransform
+	}
+	if h.DictionaryID != 0 {
+		flags |= framedDictionary
+	}
+	b := make([]byte, framedHeaderSize)
+	copy(b, framedMagic)
+	b[4] = byte(FramedVersion)
+	byteOrder.PutUint16(b[5:7], flags)
+	b[7] = byte(h.ElementType)
+	b[8] = byte(h.Level)
+	if len(h.Predictors) > 0 {
+		b = append(b, byte(len(h.Predictors)))
+		for _, name := range h.Predictors {
+			b = append(b, byte(len(name)))
+			b = append(b, name...)
+		}
+	}
+	if s := h.HashShifts; s != (HashShifts{}) {
+		b = append(b, s.FCMHistory, s.FCMValue, s.DFCMHistory, s.DFCMValue)
+	}
+	if h.Rotation != 0 {
+		b = append(b, h.Rotation)
+	}
+	if h.Channels > 1 {
+		b = append(b, byte(h.Channels), byte(h.Channels>>8))
+	}
+	if h.Transform != NoTransform {
+		b = append(b, byte(h.Transform))
+	}
+	if h.DictionaryID != 0 {
+		var id [4]byte
+		byteOrder.PutUint32(id[:], h.DictionaryID)
+		b = append(b, id[:]...)
+	}
+	return b
+}
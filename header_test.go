@@ -0,0 +1,129 @@
+package fpc
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestHeaderEncoding(t *testing.T) {
+	testcases := []Header{
+		{Level: 1, ElementType: Float64},
+		{Level: MaxCompression, ElementType: Float64, Checksums: true},
+		{Level: 10, ElementType: Float64, Segmented: true, Indexed: true},
+		{Framed: true, Version: FramedVersion, Level: 20, ElementType: Float64},
+		{Framed: true, Version: FramedVersion, Level: 3, ElementType: Float64, Checksums: true, Segmented: true, Indexed: true},
+		{Framed: true, Version: FramedVersion, Level: 5, ElementType: Float32, Predictors: []string{DFCM, "test-stride"}},
+		{Framed: true, Version: FramedVersion, Level: 5, ElementType: Float64, Extended: true},
+		{Framed: true, Version: FramedVersion, Level: 5, ElementType: Float64, Predictors: []string{FCM, Stride}, HashShifts: HashShifts{4, 40, 1, 32}},
+		{Framed: true, Version: FramedVersion, Level: 5, ElementType: Float64, Channels: 300},
+		{Framed: true, Version: FramedVersion, Level: 5, ElementType: Float64, Checksums: true, Timestamps: true},
+		{Framed: true, Version: FramedVersion, Level: 5, ElementType: Float32, Rotation: 3, Transform: DeltaZigZag},
+		{Framed: true, Version: FramedVersion, Level: 5, ElementType: Float64, Transform: ZigZag, DictionaryID: 0xDEADBEEF},
+	}
+	for i, want := range testcases {
+		b := want.encode()
+		if IsFramed(b) != want.Framed {
+			t.Errorf("IsFramed test=%d have=%v want=%v", i, !want.Framed, want.Framed)
+		}
+		have, err := ReadHeader(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("ReadHeader test=%d err=%q", i, err)
+		}
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("header round trip test=%d have=%+v want=%+v", i, have, want)
+		}
+	}
+}
+
+func TestReadHeaderInvalid(t *testing.T) {
+	testcases := [][]byte{
+		{0xFF},
+		{0xFF, 'F', 'P', 'C', FramedVersion, 0, 0, 0},                             // too short
+		{0xFF, 'F', 'P', 'X', FramedVersion, 0, 0, 0, 10},                         // bad magic
+		{0xFF, 'F', 'P', 'C', FramedVersion + 1, 0, 0, 0, 10},                     // unknown version
+		{0xFF, 'F', 'P', 'C', FramedVersion, 0, 0x80, 0, 10},                      // unknown flag
+		{0xFF, 'F', 'P', 'C', FramedVersion, 0, 0, 0xEE, 10},                      // unknown element type
+		{0xFF, 'F', 'P', 'C', FramedVersion, 8, 0, 0, 10},                         // missing predictor names
+		{0xFF, 'F', 'P', 'C', FramedVersion, 8, 0, 0, 10, 0},                      // no predictor names
+		{0xFF, 'F', 'P', 'C', FramedVersion, 8, 0, 0, 10, 2, 3, 'f', 'c', 'm', 4}, // truncated predictor name
+		{0xFF, 'F', 'P', 'C', FramedVersion, 0x20, 0, 0, 10, 6, 48, 2},            // truncated hash shifts
+		{0xFF, 'F', 'P', 'C', FramedVersion, 0x20, 0, 0, 10, 6, 64, 2, 40},        // invalid hash shift
+		{0xFF, 'F', 'P', 'C', FramedVersion, 0x80, 0, 0, 10, 3},                   // truncated channel count
+		{0xFF, 'F', 'P', 'C', FramedVersion, 0x80, 0, 0, 10, 1, 0},                // single channel
+		{0xFF, 'F', 'P', 'C', FramedVersion, 0, 4, 0, 10, 1, 2},                   // truncated dictionary ID
+		{0xFF, 'F', 'P', 'C', FramedVersion, 0, 4, 0, 10, 0, 0, 0, 0},             // zero dictionary ID
+	}
+	for i, in := range testcases {
+		_, err := ReadHeader(bytes.NewReader(in))
+		if _, ok := err.(DataError); !ok {
+			t.Errorf("ReadHeader test=%d err=%v, want DataError", i, err)
+		}
+	}
+}
+
+func TestFramedRoundTrip(t *testing.T) {
+	for _, tc := range refTests {
+		buf := new(bytes.Buffer)
+		w, err := NewWriterOptions(buf, WriterOptions{Level: int(tc.comp), Framed: true})
+		if err != nil {
+			t.Fatalf("NewWriterOptions err=%q", err)
+		}
+		for _, f := range tc.uncompressed {
+			tc.AssertNoError(t, w.WriteFloat(f), "WriteFloat")
+		}
+		tc.AssertNoError(t, w.Close(), "Close")
+
+		// The framed stream holds the same blocks as the raw one.
+		tc.AssertEqual(t, buf.Bytes()[framedHeaderSize:], tc.compressed[1:], "Writer")
+
+		r := NewReader(buf)
+		h, err := r.Header()
+		tc.AssertNoError(t, err, "Header")
+		tc.AssertEqual(t, h.Framed, true, "Header.Framed")
+		have := make([]float64, len(tc.uncompressed))
+		_, err = r.ReadFloats(have)
+		tc.AssertNoError(t, err, "ReadFloats")
+		tc.AssertEqual(t, have, tc.uncompressed, "Reader")
+	}
+}
+
+func TestFramedSeekable(t *testing.T) {
+	want := generateFloats(DefaultSegmentSize + 100)
+
+	buf := new(bytes.Buffer)
+	w, err := NewParallelWriterOptions(buf, WriterOptions{Level: DefaultCompression, Framed: true}, 2)
+	if err != nil {
+		t.Fatalf("NewParallelWriterOptions err=%q", err)
+	}
+	for _, f := range want {
+		if err := w.WriteFloat(f); err != nil {
+			t.Fatalf("WriteFloat err=%q", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+
+	h, err := ReadHeader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadHeader err=%q", err)
+	}
+	if !h.Framed || !h.Segmented || !h.Indexed {
+		t.Errorf("ReadHeader=%+v, want framed, segmented and indexed", h)
+	}
+
+	r, err := NewSeekableReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("NewSeekableReader err=%q", err)
+	}
+	have := make([]float64, 10)
+	if _, err := r.ReadFloatsAt(have, DefaultSegmentSize-5); err != nil {
+		t.Fatalf("ReadFloatsAt err=%q", err)
+	}
+	for i := range have {
+		if have[i] != want[DefaultSegmentSize-5+i] {
+			t.Fatalf("value mismatch idx=%d have=%v want=%v", i, have[i], want[DefaultSegmentSize-5+i])
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package fpc
+
+import (
+	"fmt"
+	"io"
+)
+
+// maxChannels is the most channels which a stream's header can record.
+const maxChannels = 1<<16 - 1
+
+// A MultiWriter FPC-compresses records of float64 values, one value per
+// channel, such as a reading from each of several sensors. The values are
+// interleaved into a single stream, but each channel is predicted only from
+// its own earlier values, so unrelated channels don't disturb each other's
+// predictions.
+//
+// Each channel has its own predictor tables, so a MultiWriter needs as much
+// memory as a Writer at the same compression level for every channel.
+// Streams written by a MultiWriter always have a framed header, which
+// records the number of channels. They should be read with a MultiReader,
+// although a Reader can read them too, as a single interleaved series.
+type MultiWriter struct {
+	w        *Writer
+	channels int
+}
+
+// NewMultiWriter makes a new MultiWriter which writes records of the given
+// number of channels to w, using a provided compression level. It returns an
+// error if an invalid number of channels or compression level is provided.
+func NewMultiWriter(w io.Writer, channels, level int) (*MultiWriter, error) {
+	return NewMultiWriterOptions(w, channels, WriterOptions{Level: level})
+}
+
+// NewMultiWriterOptions makes a new MultiWriter which writes records of the
+// given number of channels to w, in the format described by opts. It
+// returns an error if the number of channels or opts are invalid.
+func NewMultiWriterOptions(w io.Writer, channels int, opts WriterOptions) (*MultiWriter, error) {
+	if channels < 1 || channels > maxChannels {
+		return nil, fmt.Errorf("fpc: invalid channel count: %d", channels)
+	}
+	opts.channels = channels
+	z, err := newWriter(w, opts, Float64)
+	if err != nil {
+		return nil, err
+	}
+	return &MultiWriter{w: z, channels: channels}, nil
+}
+
+// Channels returns the number of channels in each record.
+func (w *MultiWriter) Channels() int {
+	return w.channels
+}
+
+// WriteRecord writes a record to the encoded stream, holding the value of
+// each channel in order. The length of rec must be the number of channels.
+func (w *MultiWriter) WriteRecord(rec []float64) error {
+	if len(rec) != w.channels {
+		return fmt.Errorf("fpc.WriteRecord: record has %d values, want %d", len(rec), w.channels)
+	}
+	_, err := w.w.WriteFloats(rec)
+	return err
+}
+
+// Flush will make sure all internally-buffered records are written to the
+// underlying io.Writer, even if it results in a partial block. It does not
+// flush the underlying io.Writer.
+func (w *MultiWriter) Flush() error {
+	return w.w.Flush()
+}
+
+// Close will flush the MultiWriter and make any subsequent writes return
+// errors. It does not close the underlying io.Writer.
+func (w *MultiWriter) Close() error {
+	return w.w.Close()
+}
+
+// Reset discards the MultiWriter's state and makes it equivalent to the
+// result of its original constructor, but writing to dst instead, reusing
+// its predictor tables.
+func (w *MultiWriter) Reset(dst io.Writer) {
+	w.w.Reset(dst)
+}
+
+// Stats reports what the MultiWriter has compressed since it was created or
+// last reset. Each value of each record counts separately.
+func (w *MultiWriter) Stats() Stats {
+	return w.w.Stats()
+}
+
+// A MultiReader reads records of float64 values from a stream written by a
+// MultiWriter. It can also read streams written by a Writer, as records of a
+// single value.
+type MultiReader struct {
+	r *Reader
+}
+
+// NewMultiReader creates a new MultiReader which reads and decompresses FPC
+// data from the given io.Reader.
+func NewMultiReader(r io.Reader) *MultiReader {
+	return &MultiReader{r: NewReader(r)}
+}
+
+// Channels returns the number of channels in each record, reading the
+// stream's header from the underlying io.Reader if necessary.
+func (r *MultiReader) Channels() (int, error) {
+	h, err := r.r.Header()
+	if err != nil {
+		return 0, err
+	}
+	return h.channels(), nil
+}
+
+// ReadRecord reads the next record from the stream into rec, whose length
+// must be the number of channels. If no more records are available,
+// ReadRecord returns io.EOF. If the stream ends partway through a record, it
+// returns a DataError.
+func (r *MultiReader) ReadRecord(rec []float64) error {
+	channels, err := r.Channels()
+	if err != nil {
+		return err
+	}
+	if len(rec) != channels {
+		return fmt.Errorf("fpc.ReadRecord: record has %d values, want %d", len(rec), channels)
+	}
+	n, err := r.r.ReadFloats(rec)
+	if err == io.EOF && n > 0 {
+		return dataError("stream ends partway through a record")
+	}
+	return err
+}
+
+// Header returns the header describing the format of the stream, reading it
+// from the underlying io.Reader if necessary.
+func (r *MultiReader) Header() (Header, error) {
+	return r.r.Header()
+}
+
+// Reset discards the MultiReader's state and makes it equivalent to the
+// result of its original constructor, but reading from src instead, reusing
+// its predictor tables where possible.
+func (r *MultiReader) Reset(src io.Reader) {
+	r.r.Reset(src)
+}
+
+// Stats reports what the MultiReader has decoded since it was created or
+// last reset. Each value of each record counts separately.
+func (r *MultiReader) Stats() Stats {
+	return r.r.Stats()
+}
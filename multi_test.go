@@ -0,0 +1,134 @@
+package fpc
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"testing"
+)
+
+// generateRecords makes n records of three unrelated channels: a slow sine
+// wave, a sawtooth, and a series of small integers.
+func generateRecords(n int) [][]float64 {
+	recs := make([][]float64, n)
+	for i := range recs {
+		recs[i] = []float64{
+			math.Sin(float64(i) / 100),
+			float64(i%50) * 0.25,
+			float64(i % 7),
+		}
+	}
+	return recs
+}
+
+func TestMultiWriter(t *testing.T) {
+	want := generateRecords(2*maxRecordsPerBlock/3 + 5)
+
+	buf := new(bytes.Buffer)
+	w, err := NewMultiWriter(buf, 3, 12)
+	if err != nil {
+		t.Fatalf("NewMultiWriter err=%q", err)
+	}
+	for i, rec := range want {
+		if err := w.WriteRecord(rec); err != nil {
+			t.Fatalf("WriteRecord err=%q", err)
+		}
+		if i%1001 == 0 {
+			// Flushing partway through a record shouldn't disturb the
+			// channels.
+			if err := w.Flush(); err != nil {
+				t.Fatalf("Flush err=%q", err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+	if err := w.WriteRecord([]float64{1, 2}); err == nil {
+		t.Errorf("WriteRecord of a short record should fail")
+	}
+
+	// Interleaving the same values into a single channel compresses worse.
+	single := new(bytes.Buffer)
+	sw, err := NewWriterOptions(single, WriterOptions{Level: 12, Framed: true})
+	if err != nil {
+		t.Fatalf("NewWriterOptions err=%q", err)
+	}
+	for _, rec := range want {
+		sw.WriteFloats(rec)
+	}
+	if err := sw.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+	if buf.Len() >= single.Len() {
+		t.Errorf("multi-channel stream is %d bytes, want less than %d for a single channel", buf.Len(), single.Len())
+	}
+
+	r := NewMultiReader(bytes.NewReader(buf.Bytes()))
+	if n, err := r.Channels(); err != nil || n != 3 {
+		t.Fatalf("Channels=%d, %v, want 3", n, err)
+	}
+	if err := r.ReadRecord(make([]float64, 4)); err == nil {
+		t.Errorf("ReadRecord of a long record should fail")
+	}
+	have := make([]float64, 3)
+	for i, rec := range want {
+		if err := r.ReadRecord(have); err != nil {
+			t.Fatalf("ReadRecord idx=%d err=%q", i, err)
+		}
+		for ch := range rec {
+			if have[ch] != rec[ch] {
+				t.Fatalf("value mismatch idx=%d ch=%d have=%v want=%v", i, ch, have[ch], rec[ch])
+			}
+		}
+	}
+	if err := r.ReadRecord(have); err != io.EOF {
+		t.Errorf("ReadRecord at end err=%v, want io.EOF", err)
+	}
+}
+
+func TestMultiReaderPartialRecord(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w, err := NewMultiWriter(buf, 2, 4)
+	if err != nil {
+		t.Fatalf("NewMultiWriter err=%q", err)
+	}
+	w.WriteRecord([]float64{1, 2})
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+
+	// Read the stream's values as records of a different length, by
+	// rewriting the header's channel count.
+	data := buf.Bytes()
+	data[framedHeaderSize] = 3
+	r := NewMultiReader(bytes.NewReader(data))
+	err = r.ReadRecord(make([]float64, 3))
+	if _, ok := err.(DataError); !ok {
+		t.Errorf("ReadRecord of partial record err=%v, want DataError", err)
+	}
+}
+
+func TestMultiWriterInvalid(t *testing.T) {
+	for _, channels := range []int{-1, 0, maxChannels + 1} {
+		if _, err := NewMultiWriter(new(bytes.Buffer), channels, 4); err == nil {
+			t.Errorf("NewMultiWriter channels=%d should fail", channels)
+		}
+	}
+}
+
+func TestMultiReaderLimits(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w, err := NewMultiWriter(buf, 4, 10)
+	if err != nil {
+		t.Fatalf("NewMultiWriter err=%q", err)
+	}
+	w.WriteRecord([]float64{1, 2, 3, 4})
+	w.Close()
+
+	// Each channel needs its own tables.
+	opts := ReaderOptions{MaxMemory: 2 * tableMemory(10)}
+	if _, err := NewReaderOptions(bytes.NewReader(buf.Bytes()), opts); err == nil {
+		t.Errorf("NewReaderOptions should refuse a stream needing %d bytes", 4*tableMemory(10))
+	}
+}
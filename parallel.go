@@ -0,0 +1,626 @@
+package fpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
+
+// DefaultSegmentSize is the number of values which a ParallelWriter places
+// into each independently-compressed segment.
+const DefaultSegmentSize = 8 * maxRecordsPerBlock
+
+// A ParallelWriter is an io.WriteCloser which FPC-compresses data it receives
+// using several goroutines, and writes it to an underlying writer, w.
+//
+// A ParallelWriter splits its input into segments of DefaultSegmentSize
+// values. Each segment is compressed with freshly-reset predictors, so the
+// segments can be encoded concurrently; they are written out to w in the
+// order they were received. Because predictor state doesn't carry across
+// segments, the output compresses slightly worse than a Writer's, and it
+// can only be decompressed by readers which understand segmented streams,
+// like this package's Reader.
+//
+// When closed, a ParallelWriter ends its stream with an index of its
+// segments, which allows the stream to be read with a SeekableReader.
+type ParallelWriter struct {
+	w       io.Writer
+	opts    WriterOptions
+	workers int
+
+	cur []uint64 // values waiting to be placed in a segment
+
+	started bool
+	closed  bool
+
+	work    chan *segment // segments waiting to be encoded
+	pending chan *segment // segments waiting to be written, in order
+	wg      sync.WaitGroup
+
+	mu  sync.Mutex
+	err error
+}
+
+// A segment is a unit of work for a ParallelWriter or ParallelReader.
+type segment struct {
+	vals    []uint64     // uncompressed values
+	data    bytes.Buffer // compressed blocks
+	nBlocks int          // count of blocks in data
+	offset  int64        // position of data in the stream
+	err     error
+
+	done    chan struct{} // closed once the segment has been processed
+	flushed chan struct{} // non-nil for flush markers, closed once reached
+}
+
+// NewParallelWriter makes a new ParallelWriter which writes compressed data
+// to w using the provided compression level, spreading the work of
+// compression across the given number of worker goroutines. Each worker
+// requires its own predictor tables, so the space required is O(workers *
+// 2^level) bytes. NewParallelWriter returns an error if an invalid
+// compression level or number of workers is provided.
+func NewParallelWriter(w io.Writer, level, workers int) (*ParallelWriter, error) {
+	return NewParallelWriterOptions(w, WriterOptions{Level: level}, workers)
+}
+
+// NewParallelWriterOptions makes a new ParallelWriter which writes compressed
+// data to w in the format described by opts, spreading the work of
+// compression across the given number of worker goroutines. It returns an
+// error if opts are invalid, or if an invalid number of workers is provided.
+func NewParallelWriterOptions(w io.Writer, opts WriterOptions, workers int) (*ParallelWriter, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if opts.Dictionary != nil {
+		// Segment readers start from empty tables, so they couldn't read
+		// the stream.
+		return nil, errors.New("fpc: ParallelWriter can't use a Dictionary")
+	}
+	if workers < 1 {
+		return nil, fmt.Errorf("fpc: invalid number of workers: %d", workers)
+	}
+	z := &ParallelWriter{
+		w:       w,
+		opts:    opts,
+		workers: workers,
+		cur:     make([]uint64, 0, DefaultSegmentSize),
+	}
+	return z, nil
+}
+
+// Write interprets b as a stream of byte-encoded, 64-bit IEEE 754
+// floating point values. The length of b must be a multiple of 8 in
+// order to match this expectation.
+func (w *ParallelWriter) Write(b []byte) (int, error) {
+	if len(b)%8 != 0 {
+		return 0, errors.New("fpc.Write: len of data must be a multiple of 8")
+	}
+	for i := 0; i < len(b); i += 8 {
+		if err := w.writeUint64(binary.LittleEndian.Uint64(b[i : i+8])); err != nil {
+			return i, err
+		}
+	}
+	return len(b), nil
+}
+
+// ReadFrom reads byte-encoded, 64-bit IEEE 754 floating point values from r
+// until io.EOF, and writes them to the encoded stream. Unlike Write, it
+// accepts reads of any length; it returns an error only if the data ends
+// partway through a value. It returns the number of bytes read from r.
+func (w *ParallelWriter) ReadFrom(r io.Reader) (int64, error) {
+	return readValues(r, w.writeUint64)
+}
+
+// WriteFloat writes a single float64 value to the encoded stream.
+func (w *ParallelWriter) WriteFloat(f float64) error {
+	return w.writeUint64(math.Float64bits(f))
+}
+
+// Flush encodes any internally-buffered values into a segment, even if it
+// results in a partial segment, and waits until all segments have been
+// written to w.
+//
+// Flush does not flush the underlying io.Writer which w is delegating
+// to.
+func (w *ParallelWriter) Flush() error {
+	if w.closed {
+		return errors.New("fpc: Flush on closed ParallelWriter")
+	}
+	w.start()
+	w.dispatch()
+	marker := &segment{
+		done:    make(chan struct{}),
+		flushed: make(chan struct{}),
+	}
+	close(marker.done)
+	w.pending <- marker
+	<-marker.flushed
+	return w.error()
+}
+
+// Close will flush the ParallelWriter, stop its worker goroutines, and make
+// any subsequent writes return errors. It does not close the underlying
+// io.Writer which w is delegating to.
+func (w *ParallelWriter) Close() error {
+	if w.closed == true {
+		return nil
+	}
+	w.start()
+	w.dispatch()
+	w.closed = true
+	close(w.work)
+	close(w.pending)
+	w.wg.Wait()
+	return w.error()
+}
+
+func (w *ParallelWriter) writeUint64(u uint64) error {
+	if w.closed {
+		return errors.New("fpc: write to closed ParallelWriter")
+	}
+	if err := w.error(); err != nil {
+		return err
+	}
+	w.cur = append(w.cur, u)
+	if len(w.cur) == DefaultSegmentSize {
+		w.start()
+		w.dispatch()
+	}
+	return nil
+}
+
+// start launches the worker goroutines, and the goroutine which writes their
+// output to w, if they aren't already running.
+func (w *ParallelWriter) start() {
+	if w.started {
+		return
+	}
+	w.started = true
+	w.work = make(chan *segment, w.workers)
+	w.pending = make(chan *segment, 2*w.workers)
+
+	w.wg.Add(w.workers + 1)
+	for i := 0; i < w.workers; i++ {
+		go w.encodeSegments()
+	}
+	go w.writeSegments()
+}
+
+// dispatch hands the currently-buffered values off to be encoded as a
+// segment.
+func (w *ParallelWriter) dispatch() {
+	if len(w.cur) == 0 {
+		return
+	}
+	s := &segment{
+		vals: w.cur,
+		done: make(chan struct{}),
+	}
+	w.cur = make([]uint64, 0, DefaultSegmentSize)
+	w.pending <- s
+	w.work <- s
+}
+
+// encodeSegments encodes segments as they arrive on the work channel.
+func (w *ParallelWriter) encodeSegments() {
+	defer w.wg.Done()
+	enc, err := newBlockEncoderHeader(nil, w.opts.header(Float64))
+	if err != nil {
+		w.setError(err)
+	} else {
+		enc.quant = w.opts.quantizer(Float64)
+	}
+	for s := range w.work {
+		if err != nil {
+			close(s.done)
+			continue
+		}
+		enc.w = &s.data
+		enc.enc.reset()
+		enc.xform.reset()
+		for _, v := range s.vals {
+			if err := enc.encode(v); err != nil {
+				w.setError(err)
+				break
+			}
+		}
+		if err := enc.flush(); err != nil {
+			w.setError(err)
+		}
+		close(s.done)
+	}
+}
+
+// writeSegments writes the header of the stream, and then each encoded
+// segment in the order they were dispatched.
+func (w *ParallelWriter) writeSegments() {
+	defer w.wg.Done()
+	h := w.opts.header(Float64)
+	h.Segmented, h.Indexed = true, true
+	header := h.encode()
+	_, err := w.w.Write(header)
+	if err != nil {
+		w.setError(err)
+	}
+
+	var (
+		index  []indexEntry
+		offset = int64(len(header)) // position of the next segment in the stream
+		first  int64                // ordinal of the next segment's first value
+	)
+	for s := range w.pending {
+		<-s.done
+		if s.flushed != nil {
+			close(s.flushed)
+			continue
+		}
+		if w.error() != nil {
+			continue
+		}
+		header := encodeSegmentHeader(len(s.vals), s.data.Len())
+		if _, err := w.w.Write(header); err != nil {
+			w.setError(err)
+			continue
+		}
+		index = append(index, indexEntry{offset: offset, first: first})
+		offset += int64(len(header) + s.data.Len())
+		first += int64(len(s.vals))
+		if _, err := s.data.WriteTo(w.w); err != nil {
+			w.setError(err)
+		}
+	}
+
+	// All segments have been written, so finish the stream with an index
+	// which allows it to be read by a SeekableReader.
+	if w.error() != nil {
+		return
+	}
+	if _, err := w.w.Write(encodeIndex(index, first)); err != nil {
+		w.setError(err)
+	}
+}
+
+func (w *ParallelWriter) setError(err error) {
+	w.mu.Lock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.mu.Unlock()
+}
+
+func (w *ParallelWriter) error() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// A ParallelReader provides io.Reader-style access to a stream of FPC
+// compressed data, decoding it with several goroutines.
+//
+// Only segmented streams, like those made by a ParallelWriter, can be decoded
+// concurrently. Other streams are decoded sequentially, just as a Reader
+// would.
+//
+// A ParallelReader reads ahead of its caller. Callers which stop reading
+// before reaching the end of the stream must call Close to release its
+// goroutines.
+type ParallelReader struct {
+	r       io.Reader
+	workers int
+	opts    ReaderOptions
+	header  Header
+
+	initialized bool
+	closed      bool
+	seq         *Reader // used for streams which aren't segmented
+
+	work    chan *segment // segments waiting to be decoded
+	pending chan *segment // segments waiting to be read, in order
+	quit    chan struct{} // closed to stop reading ahead
+	wg      sync.WaitGroup
+
+	cur     []uint64 // decoded values of the current segment
+	pos     int      // index of the next value to be read from cur
+	nBlocks int      // count of blocks in the segments read so far
+	offset  int64    // bytes of the stream read so far, by readSegments
+	err     error
+}
+
+// NewParallelReader creates a new ParallelReader which reads and decompresses
+// FPC data from the given io.Reader, spreading the work of decompression
+// across the given number of worker goroutines. It returns an error if an
+// invalid number of workers is provided.
+func NewParallelReader(r io.Reader, workers int) (*ParallelReader, error) {
+	if workers < 1 {
+		return nil, fmt.Errorf("fpc: invalid number of workers: %d", workers)
+	}
+	z := &ParallelReader{
+		r:       r,
+		workers: workers,
+	}
+	return z, nil
+}
+
+// NewParallelReaderOptions creates a new ParallelReader which reads and
+// decompresses FPC data from the given io.Reader, spreading the work of
+// decompression across the given number of worker goroutines, subject to
+// the limits in opts. Each worker needs its own predictor tables, so
+// opts.MaxMemory must allow for all of them. It reads the stream's header
+// immediately, returning a DataError if the stream exceeds those limits.
+func NewParallelReaderOptions(r io.Reader, workers int, opts ReaderOptions) (*ParallelReader, error) {
+	if workers < 1 {
+		return nil, fmt.Errorf("fpc: invalid number of workers: %d", workers)
+	}
+	z := &ParallelReader{
+		r:       r,
+		workers: workers,
+		opts:    opts,
+	}
+	if err := z.ensureInitialized(); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+func (r *ParallelReader) initialize() error {
+	h, err := ReadHeader(r.r)
+	if err != nil {
+		return err
+	}
+	r.initialized = true
+	r.offset = int64(len(h.encode()))
+	if !h.Segmented {
+		r.seq = &Reader{r: r.r, opts: r.opts}
+		r.seq.offset = r.offset
+		return r.seq.initializeHeader(h)
+	}
+	if err := r.opts.check(h, r.workers); err != nil {
+		return err
+	}
+	if h.ElementType.size() != 8 {
+		return fmt.Errorf("fpc: stream holds %v values, which can't be read as float64", h.ElementType)
+	}
+	r.header = h
+
+	r.work = make(chan *segment, r.workers)
+	r.pending = make(chan *segment, 2*r.workers)
+	r.quit = make(chan struct{})
+	decs := make([]*Reader, r.workers)
+	for i := range decs {
+		if decs[i], err = newSegmentReader(h); err != nil {
+			return err
+		}
+	}
+
+	r.wg.Add(r.workers + 1)
+	for _, dec := range decs {
+		go r.decodeSegments(dec)
+	}
+	go r.readSegments()
+	return nil
+}
+
+// Read reads from up to (len(buf) / 8) IEEE 754 64-bit floating point
+// values into buf. It is an error to provide a buf whose length is
+// not a multiple of 8, because that would prevent encoding of the
+// read float64s.
+//
+// If more values might be available, Read will return len(buf),
+// nil. If no more values are available, Read will return with
+// err==io.EOF
+func (r *ParallelReader) Read(buf []byte) (int, error) {
+	if len(buf)%8 != 0 {
+		return 0, errors.New("fpc: []byte passed to ParallelReader.Read must have length which is a multiple of 8")
+	}
+	if err := r.ensureInitialized(); err != nil {
+		return 0, err
+	}
+	if r.seq != nil {
+		return r.seq.Read(buf)
+	}
+	nRead := 0
+	for nRead < len(buf) {
+		if err := r.fill(); err != nil {
+			return nRead, err
+		}
+		for ; r.pos < len(r.cur) && nRead < len(buf); r.pos++ {
+			binary.LittleEndian.PutUint64(buf[nRead:], r.cur[r.pos])
+			nRead += 8
+		}
+	}
+	return nRead, nil
+}
+
+// ReadFloats will read data from the underlying io.Reader, parsing
+// the data it gets back as float64s and putting them into fs. If no
+// more values are available, ReadFloats will returns with an
+// err==io.EOF.
+func (r *ParallelReader) ReadFloats(fs []float64) (int, error) {
+	if err := r.ensureInitialized(); err != nil {
+		return 0, err
+	}
+	if r.seq != nil {
+		return r.seq.ReadFloats(fs)
+	}
+	nRead := 0
+	for nRead < len(fs) {
+		if err := r.fill(); err != nil {
+			return nRead, err
+		}
+		for ; r.pos < len(r.cur) && nRead < len(fs); r.pos++ {
+			fs[nRead] = math.Float64frombits(r.cur[r.pos])
+			nRead++
+		}
+	}
+	return nRead, nil
+}
+
+// ReadFloat will read data from the underlying io.Reader until it has
+// read enough data to provide a float64, decodes that data, and
+// returns the decoded float64. If an error is encountered while
+// reading, it returns 0 and that error. If no more values are
+// available, ReadFloat will return with err==io.EOF.
+func (r *ParallelReader) ReadFloat() (float64, error) {
+	fs := make([]float64, 1)
+	_, err := r.ReadFloats(fs)
+	if err != nil {
+		return 0, err
+	}
+	return fs[0], nil
+}
+
+// Close stops any goroutines which are decoding data ahead of the caller.
+// It does not close the underlying io.Reader which r is delegating to.
+func (r *ParallelReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if r.quit != nil {
+		close(r.quit)
+		// Drain any segments which were already queued, so that the
+		// goroutine reading segments isn't blocked from exiting.
+		for range r.pending {
+		}
+		r.wg.Wait()
+	}
+	return nil
+}
+
+func (r *ParallelReader) ensureInitialized() error {
+	if r.closed {
+		return errors.New("fpc: read from closed ParallelReader")
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if !r.initialized {
+		if err := r.initialize(); err != nil {
+			r.err = err
+			return err
+		}
+	}
+	return nil
+}
+
+// fill makes sure that there are values available in r.cur, waiting for the
+// next segment to be decoded if necessary.
+func (r *ParallelReader) fill() error {
+	for r.pos == len(r.cur) {
+		if r.err != nil {
+			return r.err
+		}
+		s, ok := <-r.pending
+		if !ok {
+			r.err = io.EOF
+			return r.err
+		}
+		<-s.done
+		// Number blocks from the start of the stream, rather than the
+		// start of the segment.
+		switch err := s.err.(type) {
+		case ChecksumError:
+			err.Block += r.nBlocks
+			s.err = err
+		case DataError:
+			if err.Block >= 0 {
+				err.Block += r.nBlocks
+				s.err = err
+			}
+		}
+		if s.err != nil {
+			r.err = s.err
+			return r.err
+		}
+		r.cur, r.pos = s.vals, 0
+		r.nBlocks += s.nBlocks
+	}
+	return nil
+}
+
+// readSegments reads each segment's compressed data off of the underlying
+// io.Reader and hands it off to be decoded.
+func (r *ParallelReader) readSegments() {
+	defer r.wg.Done()
+	defer close(r.work)
+	defer close(r.pending)
+	for {
+		s, err := r.readSegment()
+		if err != nil {
+			if err == io.EOF {
+				return
+			}
+			s = &segment{err: err, done: make(chan struct{})}
+			close(s.done)
+		}
+		select {
+		case r.pending <- s:
+		case <-r.quit:
+			return
+		}
+		if s.err != nil {
+			return
+		}
+		r.work <- s
+	}
+}
+
+// readSegment reads the next segment holding values from the underlying
+// io.Reader.
+func (r *ParallelReader) readSegment() (*segment, error) {
+	cr := countingReader{r: r.r}
+	nValues, nBytes, err := readSegmentHeader(&cr)
+	r.offset += cr.n
+	if err != nil {
+		return nil, err
+	}
+	// Read the segment's data before trusting its header enough to allocate
+	// space for its values.
+	s := &segment{
+		done:   make(chan struct{}),
+		offset: r.offset,
+	}
+	n, err := io.CopyN(&s.data, r.r, int64(nBytes))
+	r.offset += n
+	if err != nil {
+		if err == io.EOF {
+			return nil, dataError("segment too short")
+		}
+		return nil, err
+	}
+	// Each byte of a segment encodes at most two values, so this bounds the
+	// memory needed for decoding.
+	if nValues > 2*nBytes {
+		return nil, dataError("segment value count too large")
+	}
+	s.vals = make([]uint64, nValues)
+	return s, nil
+}
+
+// decodeSegments uses dec to decode segments as they arrive on the work
+// channel.
+func (r *ParallelReader) decodeSegments(dec *Reader) {
+	defer r.wg.Done()
+	for s := range r.work {
+		s.err = decodeSegment(dec, s)
+		close(s.done)
+	}
+}
+
+// decodeSegment uses dec, a Reader made by newSegmentReader, to decode the
+// compressed blocks in s.data into s.vals.
+func decodeSegment(dec *Reader, s *segment) error {
+	dec.resetSegment(&s.data, s.offset)
+	n, err := dec.ReadUint64s(s.vals)
+	s.nBlocks = dec.nBlocks
+	if err == io.EOF || (err == nil && n < len(s.vals)) {
+		return dataError("segment has fewer values than its header describes")
+	} else if err == nil && (s.data.Len() > 0 || dec.block.pos < len(dec.block.vals)) {
+		return dataError("segment has more values than its header describes")
+	}
+	return err
+}
@@ -0,0 +1,314 @@
+package fpc
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"testing"
+	"testing/iotest"
+)
+
+// generateFloats makes a slowly-varying series of n values, which exercises
+// both predictors.
+func generateFloats(n int) []float64 {
+	vals := make([]float64, n)
+	for i := range vals {
+		vals[i] = math.Sin(float64(i)/1000) * float64(i%97)
+	}
+	return vals
+}
+
+func TestParallelWriter(t *testing.T) {
+	testcases := []struct {
+		n       int
+		workers int
+	}{
+		{n: 0, workers: 1},
+		{n: 7, workers: 1},
+		{n: DefaultSegmentSize, workers: 2},
+		{n: 2*DefaultSegmentSize + 12345, workers: 4},
+	}
+	for i, tc := range testcases {
+		want := generateFloats(tc.n)
+
+		buf := new(bytes.Buffer)
+		w, err := NewParallelWriter(buf, DefaultCompression, tc.workers)
+		if err != nil {
+			t.Fatalf("NewParallelWriter err=%q", err)
+		}
+		for _, f := range want {
+			if err := w.WriteFloat(f); err != nil {
+				t.Fatalf("WriteFloat test=%d err=%q", i, err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close test=%d err=%q", i, err)
+		}
+
+		r := NewReader(buf)
+		have := make([]float64, tc.n+1)
+		n, err := r.ReadFloats(have)
+		if err != io.EOF {
+			t.Errorf("ReadFloats test=%d err=%v, want io.EOF", i, err)
+		}
+		if n != tc.n {
+			t.Fatalf("ReadFloats test=%d n=%d, want %d", i, n, tc.n)
+		}
+		for j := range want {
+			if have[j] != want[j] {
+				t.Fatalf("value mismatch test=%d idx=%d have=%v want=%v", i, j, have[j], want[j])
+			}
+		}
+	}
+}
+
+func TestParallelWriterFlush(t *testing.T) {
+	want := generateFloats(1000)
+
+	buf := new(bytes.Buffer)
+	w, err := NewParallelWriter(buf, 3, 2)
+	if err != nil {
+		t.Fatalf("NewParallelWriter err=%q", err)
+	}
+	for i, f := range want {
+		if err := w.WriteFloat(f); err != nil {
+			t.Fatalf("WriteFloat err=%q", err)
+		}
+		if i%300 == 0 {
+			if err := w.Flush(); err != nil {
+				t.Fatalf("Flush err=%q", err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+
+	r := NewReader(buf)
+	have := make([]float64, len(want))
+	if _, err := r.ReadFloats(have); err != nil {
+		t.Fatalf("ReadFloats err=%q", err)
+	}
+	for j := range want {
+		if have[j] != want[j] {
+			t.Fatalf("value mismatch idx=%d have=%v want=%v", j, have[j], want[j])
+		}
+	}
+}
+
+func TestParallelWriterReadFrom(t *testing.T) {
+	want := generateFloats(DefaultSegmentSize + 1000)
+
+	buf := new(bytes.Buffer)
+	w, err := NewParallelWriter(buf, DefaultCompression, 2)
+	if err != nil {
+		t.Fatalf("NewParallelWriter err=%q", err)
+	}
+	raw := floatBytes(want)
+	n, err := w.ReadFrom(iotest.HalfReader(bytes.NewReader(raw)))
+	if err != nil {
+		t.Fatalf("ReadFrom err=%q", err)
+	}
+	if n != int64(len(raw)) {
+		t.Errorf("ReadFrom n=%d, want %d", n, len(raw))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+
+	have := new(bytes.Buffer)
+	if _, err := io.Copy(have, NewReader(buf)); err != nil {
+		t.Fatalf("io.Copy err=%q", err)
+	}
+	if !bytes.Equal(have.Bytes(), raw) {
+		t.Errorf("ReadFrom round trip mismatch")
+	}
+}
+
+func TestParallelReader(t *testing.T) {
+	want := generateFloats(3*DefaultSegmentSize + 999)
+
+	buf := new(bytes.Buffer)
+	w, err := NewParallelWriter(buf, DefaultCompression, 3)
+	if err != nil {
+		t.Fatalf("NewParallelWriter err=%q", err)
+	}
+	for _, f := range want {
+		if err := w.WriteFloat(f); err != nil {
+			t.Fatalf("WriteFloat err=%q", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+
+	for _, workers := range []int{1, 2, 8} {
+		r, err := NewParallelReader(bytes.NewReader(buf.Bytes()), workers)
+		if err != nil {
+			t.Fatalf("NewParallelReader err=%q", err)
+		}
+		have := make([]float64, len(want)+1)
+		n, err := r.ReadFloats(have)
+		if err != io.EOF {
+			t.Errorf("ReadFloats workers=%d err=%v, want io.EOF", workers, err)
+		}
+		if n != len(want) {
+			t.Fatalf("ReadFloats workers=%d n=%d, want %d", workers, n, len(want))
+		}
+		for j := range want {
+			if have[j] != want[j] {
+				t.Fatalf("value mismatch workers=%d idx=%d have=%v want=%v", workers, j, have[j], want[j])
+			}
+		}
+		if err := r.Close(); err != nil {
+			t.Errorf("Close err=%q", err)
+		}
+	}
+}
+
+func TestParallelReaderUnsegmented(t *testing.T) {
+	for _, tc := range refTests {
+		r, err := NewParallelReader(bytes.NewReader(tc.compressed), 2)
+		if err != nil {
+			t.Fatalf("NewParallelReader err=%q", err)
+		}
+		have := make([]float64, len(tc.uncompressed))
+		_, err = r.ReadFloats(have)
+		tc.AssertNoError(t, err, "ReadFloats")
+		tc.AssertEqual(t, have, tc.uncompressed, "ParallelReader")
+	}
+}
+
+func TestParallelReaderClose(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w, err := NewParallelWriter(buf, DefaultCompression, 2)
+	if err != nil {
+		t.Fatalf("NewParallelWriter err=%q", err)
+	}
+	for _, f := range generateFloats(10 * DefaultSegmentSize) {
+		w.WriteFloat(f)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+
+	// Stop reading partway through the stream.
+	r, err := NewParallelReader(buf, 2)
+	if err != nil {
+		t.Fatalf("NewParallelReader err=%q", err)
+	}
+	if _, err := r.ReadFloat(); err != nil {
+		t.Fatalf("ReadFloat err=%q", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+	if _, err := r.ReadFloat(); err == nil {
+		t.Errorf("ReadFloat after Close should fail")
+	}
+}
+
+func TestParallelReaderHugeSegment(t *testing.T) {
+	// A segment header claiming billions of values in a few bytes must be
+	// rejected without allocating space for them.
+	in := []byte{1 | segmentedFlag}
+	in = append(in, 0xFE, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x7F)
+	in = append(in, 1, 2, 3)
+	r, err := NewParallelReader(bytes.NewReader(in), 2)
+	if err != nil {
+		t.Fatalf("NewParallelReader err=%q", err)
+	}
+	defer r.Close()
+	if _, err := r.ReadFloat(); err == nil {
+		t.Errorf("ReadFloat of a truncated huge segment should fail")
+	} else if _, ok := err.(DataError); !ok {
+		t.Errorf("ReadFloat err=%v, want DataError", err)
+	}
+}
+
+func TestParallelReaderLimits(t *testing.T) {
+	testcases := []struct {
+		in      []byte
+		workers int
+		opts    ReaderOptions
+		wantErr bool
+	}{
+		{in: []byte{10 | segmentedFlag}, workers: 4, wantErr: false},
+		{in: []byte{10 | segmentedFlag}, workers: 4, opts: ReaderOptions{MaxLevel: 5}, wantErr: true},
+		{in: []byte{MaxCompression | segmentedFlag}, workers: 4, opts: ReaderOptions{MaxMemory: 1 << 30}, wantErr: true},
+		// Each worker has its own tables.
+		{in: []byte{10 | segmentedFlag}, workers: 2, opts: ReaderOptions{MaxMemory: 40 << 10}, wantErr: false},
+		{in: []byte{10 | segmentedFlag}, workers: 4, opts: ReaderOptions{MaxMemory: 40 << 10}, wantErr: true},
+		// Streams which aren't segmented are decoded by a single Reader.
+		{in: []byte{10}, workers: 4, opts: ReaderOptions{MaxMemory: 40 << 10}, wantErr: false},
+		{in: []byte{10}, workers: 4, opts: ReaderOptions{MaxLevel: 5}, wantErr: true},
+	}
+	for i, tc := range testcases {
+		r, err := NewParallelReaderOptions(bytes.NewReader(tc.in), tc.workers, tc.opts)
+		if tc.wantErr {
+			if _, ok := err.(DataError); !ok {
+				t.Errorf("NewParallelReaderOptions test=%d err=%v, want DataError", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NewParallelReaderOptions test=%d err=%q", i, err)
+			continue
+		}
+		r.Close()
+	}
+}
+
+func TestParallelChecksums(t *testing.T) {
+	want := generateFloats(2*DefaultSegmentSize + 5)
+
+	buf := new(bytes.Buffer)
+	w, err := NewParallelWriterOptions(buf, WriterOptions{Level: DefaultCompression, Checksums: true}, 2)
+	if err != nil {
+		t.Fatalf("NewParallelWriterOptions err=%q", err)
+	}
+	for _, f := range want {
+		if err := w.WriteFloat(f); err != nil {
+			t.Fatalf("WriteFloat err=%q", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+	data := buf.Bytes()
+
+	r, err := NewParallelReader(bytes.NewReader(data), 2)
+	if err != nil {
+		t.Fatalf("NewParallelReader err=%q", err)
+	}
+	have := make([]float64, len(want))
+	if _, err := r.ReadFloats(have); err != nil {
+		t.Fatalf("ReadFloats err=%q", err)
+	}
+	for i := range want {
+		if have[i] != want[i] {
+			t.Fatalf("value mismatch idx=%d have=%v want=%v", i, have[i], want[i])
+		}
+	}
+
+	// Corrupt the start of the second segment's first block, just after its
+	// block and record headers.
+	seg, err := NewSeekableReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("NewSeekableReader err=%q", err)
+	}
+	off := seg.index[1].offset + segmentHeaderSize + blockHeaderSize + maxRecordsPerBlock/2 + 1
+	data[off] ^= 0x01
+
+	r, err = NewParallelReader(bytes.NewReader(data), 2)
+	if err != nil {
+		t.Fatalf("NewParallelReader err=%q", err)
+	}
+	_, err = r.ReadFloats(have)
+	if cerr, ok := err.(ChecksumError); !ok {
+		t.Errorf("ReadFloats of corrupted data err=%v, want ChecksumError", err)
+	} else if want := DefaultSegmentSize / maxRecordsPerBlock; cerr.Block != want {
+		t.Errorf("ChecksumError.Block=%d, want %d", cerr.Block, want)
+	}
+	r.Close()
+}
@@ -1,5 +1,10 @@
 package fpc
 
+import (
+	"fmt"
+	"sync"
+)
+
 type predictorClass uint8
 
 const (
@@ -10,24 +15,88 @@ const (
 type predictor interface {
 	predict() (predicted uint64)
 	update(actual uint64)
+	reset()
+}
+
+// HashShifts configure the hash functions of the FCM and DFCM predictors,
+// which pick the entry of a predictor's table to use for each value. Each
+// hash is formed by shifting the previous hash left, and combining it with
+// the top bits of the latest value (for FCM) or of the difference between
+// the latest two values (for DFCM), shifted right.
+//
+// Larger history shifts make the hash depend on fewer previous values.
+// Smaller value shifts make it depend on more of each value's mantissa.
+//
+// The zero HashShifts selects the defaults for a stream's element type, which
+// are those of the reference implementation for float64 values.
+type HashShifts struct {
+	FCMHistory  uint8 // left shift of the previous FCM hash
+	FCMValue    uint8 // right shift of each value in the FCM hash
+	DFCMHistory uint8 // left shift of the previous DFCM hash
+	DFCMValue   uint8 // right shift of each difference in the DFCM hash
+}
+
+// defaultHashShifts returns the shifts used for values of type t when none
+// are specified. For float32 values, the value shifts take the same bits
+// from the top of each value as the float64 shifts do.
+func defaultHashShifts(t ElementType) HashShifts {
+	if t.size() == 4 {
+		return HashShifts{FCMHistory: 6, FCMValue: 19, DFCMHistory: 2, DFCMValue: 11}
+	}
+	return HashShifts{FCMHistory: 6, FCMValue: 48, DFCMHistory: 2, DFCMValue: 40}
+}
+
+// orDefault returns s, or the default shifts for t if s is zero.
+func (s HashShifts) orDefault(t ElementType) HashShifts {
+	if s == (HashShifts{}) {
+		return defaultHashShifts(t)
+	}
+	return s
+}
+
+// check returns an error if any shift is too large to be meaningful.
+func (s HashShifts) check() error {
+	for _, shift := range []uint8{s.FCMHistory, s.FCMValue, s.DFCMHistory, s.DFCMValue} {
+		if shift >= 64 {
+			return fmt.Errorf("fpc: invalid hash shift: %d", shift)
+		}
+	}
+	return nil
 }
 
 type fcm struct {
 	table    []uint64
 	size     uint64
 	lastHash uint64
+
+	historyShift uint
+	valueShift   uint
 }
 
 func newFCM(size uint) *fcm {
+	s := defaultHashShifts(Float64)
+	return newFCMShifts(size, s.FCMHistory, s.FCMValue)
+}
+
+func newFCMShifts(size uint, historyShift, valueShift uint8) *fcm {
 	// size must be a power of two
 	return &fcm{
-		table: make([]uint64, size, size),
-		size:  uint64(size),
+		table:        make([]uint64, size, size),
+		size:         uint64(size),
+		historyShift: uint(historyShift),
+		valueShift:   uint(valueShift),
 	}
 }
 
+func (f *fcm) reset() {
+	for i := range f.table {
+		f.table[i] = 0
+	}
+	f.lastHash = 0
+}
+
 func (f *fcm) hash(actual uint64) uint64 {
-	return ((f.lastHash << 6) ^ (actual >> 48)) & (f.size - 1)
+	return ((f.lastHash << f.historyShift) ^ (actual >> f.valueShift)) & (f.size - 1)
 }
 
 func (f *fcm) predict() uint64 {
@@ -39,31 +108,337 @@ func (f *fcm) update(actual uint64) {
 	f.lastHash = f.hash(actual)
 }
 
+// dfcm predicts differences between values. For 32-bit values, differences
+// are computed modulo 2^32.
 type dfcm struct {
 	table     []uint64
 	size      uint64
 	lastHash  uint64
 	lastValue uint64
+
+	historyShift uint
+	valueShift   uint
+	mask         uint64 // bits which the element type can hold
 }
 
 func newDFCM(size uint) *dfcm {
+	s := defaultHashShifts(Float64)
+	return newDFCMShifts(size, s.DFCMHistory, s.DFCMValue, Float64)
+}
+
+func newDFCMShifts(size uint, historyShift, valueShift uint8, t ElementType) *dfcm {
 	// size must be a power of two
-	return &dfcm{
-		table: make([]uint64, size, size),
-		size:  uint64(size),
+	d := &dfcm{
+		table:        make([]uint64, size, size),
+		size:         uint64(size),
+		historyShift: uint(historyShift),
+		valueShift:   uint(valueShift),
+		mask:         1<<64 - 1,
+	}
+	if t.size() == 4 {
+		d.mask = mask32
+	}
+	return d
+}
+
+func (d *dfcm) reset() {
+	for i := range d.table {
+		d.table[i] = 0
 	}
+	d.lastHash = 0
+	d.lastValue = 0
 }
 
 func (d *dfcm) hash(actual uint64) uint64 {
-	return ((d.lastHash << 2) ^ ((actual - d.lastValue) >> 40)) & (d.size - 1)
+	return ((d.lastHash << d.historyShift) ^ (((actual - d.lastValue) & d.mask) >> d.valueShift)) & (d.size - 1)
 }
 
 func (d *dfcm) predict() uint64 {
-	return d.table[d.lastHash] + d.lastValue
+	return (d.table[d.lastHash] + d.lastValue) & d.mask
 }
 
 func (d *dfcm) update(actual uint64) {
-	d.table[d.lastHash] = actual - d.lastValue
+	d.table[d.lastHash] = (actual - d.lastValue) & d.mask
 	d.lastHash = d.hash(actual)
 	d.lastValue = actual
 }
+
+const mask32 = 1<<32 - 1
+
+// newPredictors creates the FCM and DFCM predictors for a stream of values
+// of the given type, with tables of 2^level entries, using the given hash
+// shifts or the defaults if they are zero.
+func newPredictors(level uint, t ElementType, s HashShifts) (fcm, dfcm predictor) {
+	tableSize := uint(1 << level)
+	s = s.orDefault(t)
+	return newFCMShifts(tableSize, s.FCMHistory, s.FCMValue),
+		newDFCMShifts(tableSize, s.DFCMHistory, s.DFCMValue, t)
+}
+
+// A Predictor guesses each value in a stream from the values which came
+// before it. Values are passed as the bit patterns of IEEE 754 floating point
+// numbers. In streams of float32 values, only the low 32 bits are used.
+//
+// An encoder and decoder must make identical predictions for a stream to be
+// decoded correctly, so a Predictor's behavior must depend only on the values
+// it has been given since it was made or reset.
+type Predictor interface {
+	// Predict returns a guess at the next value in the stream.
+	Predict() uint64
+	// Update tells the Predictor the actual value which followed its last
+	// prediction.
+	Update(actual uint64)
+	// Reset returns the Predictor to its initial state.
+	Reset()
+}
+
+// A PredictorFunc makes a Predictor for a stream of values of type t. The
+// stream's compression level can be used to size any tables the Predictor
+// needs.
+type PredictorFunc func(level int, t ElementType) Predictor
+
+// Names of the built-in predictors. Streams use FCM and DFCM unless they
+// name others; extended streams also use LastValue and Stride by default.
+const (
+	FCM       = "fcm"    // finite context method
+	DFCM      = "dfcm"   // differential finite context method
+	LastValue = "last"   // predicts a repeat of the previous value
+	Stride    = "stride" // predicts the same difference as between the previous two values
+)
+
+// isBuiltinPredictor reports whether name is that of a built-in predictor.
+func isBuiltinPredictor(name string) bool {
+	switch name {
+	case FCM, DFCM, LastValue, Stride:
+		return true
+	}
+	return false
+}
+
+var (
+	predictorsMu sync.RWMutex
+	predictors   = make(map[string]PredictorFunc)
+)
+
+// RegisterPredictor makes a predictor available by name, for use in
+// WriterOptions.Predictors. Streams record the names of the predictors they
+// use, so a predictor must be registered under the same name by programs
+// which write and read a stream. RegisterPredictor panics if it is called
+// twice for the same name, or if the name is empty, longer than 255 bytes, or
+// that of a built-in predictor.
+func RegisterPredictor(name string, f PredictorFunc) {
+	predictorsMu.Lock()
+	defer predictorsMu.Unlock()
+	if f == nil {
+		panic("fpc: RegisterPredictor function is nil")
+	}
+	if name == "" || len(name) > 255 {
+		panic("fpc: RegisterPredictor name must have between 1 and 255 bytes")
+	}
+	if _, dup := predictors[name]; dup || isBuiltinPredictor(name) {
+		panic("fpc: RegisterPredictor called twice for predictor " + name)
+	}
+	predictors[name] = f
+}
+
+// predictorNames returns the names of the predictors used by a stream
+// described by h.
+func predictorNames(h Header) []string {
+	switch {
+	case len(h.Predictors) > 0:
+		return h.Predictors
+	case h.Extended:
+		return []string{FCM, DFCM, LastValue, Stride}
+	default:
+		return []string{FCM, DFCM}
+	}
+}
+
+// numPredictors returns the number of predictors used by each record of a
+// stream, which depends on whether the stream is extended.
+func numPredictors(extended bool) int {
+	if extended {
+		return 4
+	}
+	return 2
+}
+
+// newStreamPredictors creates the predictors for a stream described by h, in
+// the order in which records refer to them.
+func newStreamPredictors(h Header) ([]predictor, error) {
+	names := predictorNames(h)
+	if err := checkPredictors(names, numPredictors(h.Extended)); err != nil {
+		return nil, err
+	}
+	if h.channels() == 1 {
+		return newPredictorSet(h, names), nil
+	}
+
+	// Each channel gets its own set of predictors, and each of the stream's
+	// predictors switches between the channels' in turn.
+	ps := make([]predictor, len(names))
+	chans := make([]*channelPredictor, len(names))
+	for i := range ps {
+		chans[i] = &channelPredictor{ps: make([]predictor, h.channels())}
+		ps[i] = chans[i]
+	}
+	for ch := 0; ch < h.channels(); ch++ {
+		for i, p := range newPredictorSet(h, names) {
+			chans[i].ps[ch] = p
+		}
+	}
+	return ps, nil
+}
+
+// newPredictorSet makes the named predictors, for a stream described by h.
+func newPredictorSet(h Header, names []string) []predictor {
+	level, t := uint(h.Level), h.ElementType
+	var fcm, dfcm predictor
+	for _, name := range names {
+		if name == FCM || name == DFCM {
+			fcm, dfcm = newPredictors(level, t, h.hashShifts())
+			break
+		}
+	}
+	ps := make([]predictor, len(names))
+	for i, name := range names {
+		switch name {
+		case FCM:
+			ps[i] = fcm
+		case DFCM:
+			ps[i] = dfcm
+		case LastValue:
+			ps[i] = new(lastValue)
+		case Stride:
+			ps[i] = newStride(t)
+		default:
+			predictorsMu.RLock()
+			f := predictors[name]
+			predictorsMu.RUnlock()
+			ps[i] = newCustomPredictor(f(int(level), t), t)
+		}
+	}
+	return ps
+}
+
+// checkPredictors returns an error unless names holds the names of n
+// different, known predictors.
+func checkPredictors(names []string, n int) error {
+	if len(names) != n {
+		return fmt.Errorf("fpc: stream needs %d predictors, not %d", n, len(names))
+	}
+	predictorsMu.RLock()
+	defer predictorsMu.RUnlock()
+	for i, name := range names {
+		if _, ok := predictors[name]; !ok && !isBuiltinPredictor(name) {
+			return fmt.Errorf("fpc: unknown predictor %q", name)
+		}
+		for _, other := range names[:i] {
+			if name == other {
+				return fmt.Errorf("fpc: predictor %q used twice", name)
+			}
+		}
+	}
+	return nil
+}
+
+// lastValue predicts that each value repeats the one before it.
+type lastValue struct {
+	last uint64
+}
+
+func (p *lastValue) predict() uint64 {
+	return p.last
+}
+
+func (p *lastValue) update(actual uint64) {
+	p.last = actual
+}
+
+func (p *lastValue) reset() {
+	p.last = 0
+}
+
+// stride predicts that the difference between each value and the one before
+// it is the same as the previous difference. This is exact for evenly-spaced
+// values which share an exponent, like timestamps or grid coordinates.
+type stride struct {
+	last   uint64
+	stride uint64
+	mask   uint64 // bits which the element type can hold
+}
+
+func newStride(t ElementType) *stride {
+	s := &stride{mask: 1<<64 - 1}
+	if t.size() == 4 {
+		s.mask = mask32
+	}
+	return s
+}
+
+func (p *stride) predict() uint64 {
+	return (p.last + p.stride) & p.mask
+}
+
+func (p *stride) update(actual uint64) {
+	p.stride = actual - p.last
+	p.last = actual
+}
+
+func (p *stride) reset() {
+	p.last = 0
+	p.stride = 0
+}
+
+// customPredictor adapts a Predictor for use by encoders and decoders.
+type customPredictor struct {
+	p    Predictor
+	mask uint64 // bits which the element type can hold
+}
+
+func newCustomPredictor(p Predictor, t ElementType) *customPredictor {
+	mask := uint64(1<<64 - 1)
+	if t.size() == 4 {
+		mask = mask32
+	}
+	return &customPredictor{p: p, mask: mask}
+}
+
+func (c *customPredictor) predict() uint64 {
+	return c.p.Predict() & c.mask
+}
+
+func (c *customPredictor) update(actual uint64) {
+	c.p.Update(actual)
+}
+
+func (c *customPredictor) reset() {
+	c.p.Reset()
+}
+
+// A channelPredictor predicts values for a stream with several interleaved
+// channels. It holds one predictor per channel, and moves on to the next
+// channel's predictor with each update.
+type channelPredictor struct {
+	ps []predictor
+	ch int // channel of the next value
+}
+
+func (c *channelPredictor) predict() uint64 {
+	return c.ps[c.ch].predict()
+}
+
+func (c *channelPredictor) update(actual uint64) {
+	c.ps[c.ch].update(actual)
+	c.ch++
+	if c.ch == len(c.ps) {
+		c.ch = 0
+	}
+}
+
+func (c *channelPredictor) reset() {
+	for _, p := range c.ps {
+		p.reset()
+	}
+	c.ch = 0
+}
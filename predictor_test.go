@@ -0,0 +1,210 @@
+package fpc
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// strideTestPredictor predicts that the difference between consecutive
+// values stays the same.
+type strideTestPredictor struct {
+	last, stride uint64
+}
+
+func (p *strideTestPredictor) Predict() uint64 { return p.last + p.stride }
+
+func (p *strideTestPredictor) Update(actual uint64) {
+	p.stride = actual - p.last
+	p.last = actual
+}
+
+func (p *strideTestPredictor) Reset() { *p = strideTestPredictor{} }
+
+func init() {
+	RegisterPredictor("test-stride", func(int, ElementType) Predictor {
+		return new(strideTestPredictor)
+	})
+}
+
+func TestCustomPredictors(t *testing.T) {
+	want := generateFloats(maxRecordsPerBlock + 77)
+	for _, names := range [][]string{
+		{"test-stride", FCM},
+		{DFCM, "test-stride"},
+		{DFCM, FCM},
+	} {
+		buf := new(bytes.Buffer)
+		w, err := NewWriterOptions(buf, WriterOptions{Level: 8, Predictors: names})
+		if err != nil {
+			t.Fatalf("NewWriterOptions names=%v err=%q", names, err)
+		}
+		if _, err := w.WriteFloats(want); err != nil {
+			t.Fatalf("WriteFloats names=%v err=%q", names, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close names=%v err=%q", names, err)
+		}
+
+		r := NewReader(buf)
+		h, err := r.Header()
+		if err != nil {
+			t.Fatalf("Header names=%v err=%q", names, err)
+		}
+		if !h.Framed || !reflect.DeepEqual(h.Predictors, names) {
+			t.Errorf("Header names=%v have=%+v", names, h)
+		}
+		have := make([]float64, len(want)+1)
+		n, err := r.ReadFloats(have)
+		if err != io.EOF {
+			t.Errorf("ReadFloats names=%v err=%v, want io.EOF", names, err)
+		}
+		if n != len(want) {
+			t.Fatalf("ReadFloats names=%v n=%d, want %d", names, n, len(want))
+		}
+		for i := range want {
+			if have[i] != want[i] {
+				t.Fatalf("value mismatch names=%v idx=%d have=%v want=%v", names, i, have[i], want[i])
+			}
+		}
+	}
+}
+
+func TestCustomPredictorsParallel(t *testing.T) {
+	want := generateFloats(2*DefaultSegmentSize + 3)
+	buf := new(bytes.Buffer)
+	opts := WriterOptions{Level: 8, Predictors: []string{FCM, "test-stride"}}
+	w, err := NewParallelWriterOptions(buf, opts, 2)
+	if err != nil {
+		t.Fatalf("NewParallelWriterOptions err=%q", err)
+	}
+	for _, f := range want {
+		w.WriteFloat(f)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+
+	r, err := NewParallelReader(bytes.NewReader(buf.Bytes()), 2)
+	if err != nil {
+		t.Fatalf("NewParallelReader err=%q", err)
+	}
+	have := make([]float64, len(want))
+	if _, err := r.ReadFloats(have); err != nil {
+		t.Fatalf("ReadFloats err=%q", err)
+	}
+	for i := range want {
+		if have[i] != want[i] {
+			t.Fatalf("value mismatch idx=%d have=%v want=%v", i, have[i], want[i])
+		}
+	}
+	r.Close()
+}
+
+func TestInvalidPredictors(t *testing.T) {
+	for _, names := range [][]string{
+		{FCM},
+		{FCM, FCM},
+		{FCM, DFCM, "test-stride"},
+		{FCM, "unregistered"},
+	} {
+		_, err := NewWriterOptions(ioutil.Discard, WriterOptions{Level: 8, Predictors: names})
+		if err == nil {
+			t.Errorf("NewWriterOptions names=%v should fail", names)
+		}
+	}
+	_, err := NewWriterOptions(ioutil.Discard, WriterOptions{Level: 1, Extended: true, Predictors: []string{FCM, DFCM}})
+	if err == nil {
+		t.Errorf("NewWriterOptions should refuse two predictors for an extended stream")
+	}
+
+	// Readers refuse streams whose predictors they don't know.
+	h := Header{Framed: true, Version: FramedVersion, Level: 8, Predictors: []string{FCM, "unregistered"}}
+	if _, err := NewReader(bytes.NewReader(h.encode())).ReadFloat(); err == nil || err == io.EOF {
+		t.Errorf("ReadFloat err=%v, want unknown predictor error", err)
+	}
+}
+
+func TestExtended(t *testing.T) {
+	// Short runs of random values, which the last-value predictor gets
+	// exactly right, and which are hard for small FCM and DFCM tables.
+	rng := rand.New(rand.NewSource(1))
+	want := make([]float64, 3*maxRecordsPerBlock+11)
+	for i := range want {
+		if i%4 == 0 {
+			want[i] = rng.Float64() * 100
+		} else {
+			want[i] = want[i-1]
+		}
+	}
+	compress := func(opts WriterOptions) []byte {
+		buf := new(bytes.Buffer)
+		w, err := NewWriterOptions(buf, opts)
+		if err != nil {
+			t.Fatalf("NewWriterOptions opts=%+v err=%q", opts, err)
+		}
+		if _, err := w.WriteFloats(want); err != nil {
+			t.Fatalf("WriteFloats opts=%+v err=%q", opts, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close opts=%+v err=%q", opts, err)
+		}
+		return buf.Bytes()
+	}
+
+	standard := compress(WriterOptions{Level: 1})
+	for _, opts := range []WriterOptions{
+		{Level: 1, Extended: true},
+		{Level: 1, Extended: true, Checksums: true},
+		{Level: 1, Extended: true, Predictors: []string{Stride, "test-stride", FCM, LastValue}},
+	} {
+		data := compress(opts)
+		if len(data) >= len(standard) {
+			t.Errorf("extended stream opts=%+v is %d bytes, not smaller than standard stream of %d bytes", opts, len(data), len(standard))
+		}
+
+		r := NewReader(bytes.NewReader(data))
+		h, err := r.Header()
+		if err != nil {
+			t.Fatalf("Header opts=%+v err=%q", opts, err)
+		}
+		if !h.Framed || !h.Extended {
+			t.Errorf("Header opts=%+v have=%+v, want framed and extended", opts, h)
+		}
+		have := make([]float64, len(want))
+		if _, err := r.ReadFloats(have); err != nil {
+			t.Fatalf("ReadFloats opts=%+v err=%q", opts, err)
+		}
+		for i := range want {
+			if have[i] != want[i] {
+				t.Fatalf("value mismatch opts=%+v idx=%d have=%v want=%v", opts, i, have[i], want[i])
+			}
+		}
+	}
+}
+
+func TestExtended32(t *testing.T) {
+	want := generateFloat32s(maxRecordsPerBlock + 5)
+	buf := new(bytes.Buffer)
+	w, err := NewWriter32Options(buf, WriterOptions{Level: 1, Extended: true})
+	if err != nil {
+		t.Fatalf("NewWriter32Options err=%q", err)
+	}
+	w.WriteFloat32s(want)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+
+	have := make([]float32, len(want))
+	if _, err := NewReader32(buf).ReadFloat32s(have); err != nil {
+		t.Fatalf("ReadFloat32s err=%q", err)
+	}
+	for i := range want {
+		if have[i] != want[i] {
+			t.Fatalf("value mismatch idx=%d have=%v want=%v", i, have[i], want[i])
+		}
+	}
+}
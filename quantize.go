@@ -0,0 +1,177 @@
+package fpc
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// Lossy streams are written by rounding each value before it is compressed,
+// within an error bound given in WriterOptions. Rounding only makes values
+// more predictable; the format is unchanged, so any reader can decode them.
+//
+// FPC residuals lose their leading zero bytes, but not their trailing ones,
+// so bounds which keep a fixed number of mantissa bits also rotate each value
+// right by the number of bits discarded, moving the zeroed bits to the top.
+// The rotation is recorded in the stream's framed header, and readers undo
+// it. Values which can't be rounded within the bound are kept exactly, and
+// survive the rotation intact, just less compressed.
+
+// A quantizer rounds values to be written to a lossy stream.
+type quantizer struct {
+	width    int     // size of each value in bytes
+	drop     uint    // low mantissa bits to zero, for fixed-precision bounds
+	relative float64 // relative error bound, or zero
+	absolute float64 // absolute error bound, or zero
+	step     float64 // spacing of rounded values, for absolute bounds
+}
+
+// mantissaBits returns the number of explicit mantissa bits in values of type
+// t.
+func mantissaBits(t ElementType) int {
+	if t.size() == 4 {
+		return 23
+	}
+	return 52
+}
+
+// checkLossy returns an error unless o describes at most one valid error
+// bound.
+func (o WriterOptions) checkLossy() error {
+	n := 0
+	if o.MantissaBits != 0 {
+		n++
+		if o.MantissaBits < 1 || o.MantissaBits > 52 {
+			return fmt.Errorf("fpc: invalid number of mantissa bits: %d", o.MantissaBits)
+		}
+	}
+	if o.RelativeError != 0 {
+		n++
+		if !(o.RelativeError > 0 && o.RelativeError < 1) {
+			return fmt.Errorf("fpc: invalid relative error bound: %v", o.RelativeError)
+		}
+	}
+	if o.AbsoluteError != 0 {
+		n++
+		if !(o.AbsoluteError > 0 && o.AbsoluteError <= math.MaxFloat64) {
+			return fmt.Errorf("fpc: invalid absolute error bound: %v", o.AbsoluteError)
+		}
+	}
+	if n > 1 {
+		return fmt.Errorf("fpc: only one of MantissaBits, RelativeError and AbsoluteError may be set")
+	}
+	return nil
+}
+
+// quantizer returns the quantizer for a stream of values of type t written
+// with o, or nil if the stream is lossless.
+func (o WriterOptions) quantizer(t ElementType) *quantizer {
+	q := &quantizer{width: t.size()}
+	keep := mantissaBits(t)
+	switch {
+	case o.MantissaBits != 0:
+		if o.MantissaBits < keep {
+			keep = o.MantissaBits
+		}
+	case o.RelativeError != 0:
+		// Rounding to k mantissa bits changes a value by at most
+		// 2^-(k+1) of its magnitude.
+		k := int(math.Ceil(-math.Log2(o.RelativeError) - 1))
+		if k < keep {
+			keep = k
+		}
+		if keep < 0 {
+			keep = 0
+		}
+		q.relative = o.RelativeError
+	case o.AbsoluteError != 0:
+		// Rounding to a multiple of 2^m changes a value by at most
+		// 2^(m-1).
+		q.absolute = o.AbsoluteError
+		q.step = math.Ldexp(1, int(math.Floor(math.Log2(o.AbsoluteError)))+1)
+		return q
+	default:
+		return nil
+	}
+	q.drop = uint(mantissaBits(t) - keep)
+	if q.drop == 0 {
+		return nil
+	}
+	return q
+}
+
+// rotation returns the number of bits by which values are rotated right after
+// being rounded.
+func (q *quantizer) rotation() uint8 {
+	if q == nil {
+		return 0
+	}
+	return uint8(q.drop)
+}
+
+// quantize rounds v, the bit pattern of a value, and then rotates it.
+func (q *quantizer) quantize(v uint64) uint64 {
+	if q.width == 4 {
+		return uint64(bits.RotateLeft32(q.quantize32(uint32(v)), -int(q.drop)))
+	}
+	return bits.RotateLeft64(q.quantize64(v), -int(q.drop))
+}
+
+func (q *quantizer) quantize64(v uint64) uint64 {
+	f := math.Float64frombits(v)
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return v
+	}
+	var r float64
+	if q.absolute != 0 {
+		r = math.Round(f/q.step) * q.step
+		if !(math.Abs(r-f) <= q.absolute) {
+			return v
+		}
+		return math.Float64bits(r)
+	}
+	rv := roundMantissa(v, q.drop, 52, 0x7FF)
+	r = math.Float64frombits(rv)
+	if q.relative != 0 && !(math.Abs(r-f) <= q.relative*math.Abs(f)) {
+		// This happens for subnormal values, whose precision is
+		// limited.
+		return v
+	}
+	return rv
+}
+
+func (q *quantizer) quantize32(v uint32) uint32 {
+	f := float64(math.Float32frombits(v))
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return v
+	}
+	if q.absolute != 0 {
+		r := float32(math.Round(f/q.step) * q.step)
+		if !(math.Abs(float64(r)-f) <= q.absolute) {
+			return v
+		}
+		return math.Float32bits(r)
+	}
+	rv := uint32(roundMantissa(uint64(v), q.drop, 23, 0xFF))
+	r := float64(math.Float32frombits(rv))
+	if q.relative != 0 && !(math.Abs(r-f) <= q.relative*math.Abs(f)) {
+		return v
+	}
+	return rv
+}
+
+// roundMantissa rounds the floating point value with bit pattern v to the
+// nearest value whose low drop bits are zero, for a format with nMantissa
+// explicit mantissa bits and an exponent whose bits are all set for
+// infinities. Values which would round up to infinity are truncated instead.
+func roundMantissa(v uint64, drop uint, nMantissa uint, maxExp uint64) uint64 {
+	if drop == 0 {
+		return v
+	}
+	mask := uint64(1)<<drop - 1
+	r := (v + (mask>>1 + 1)) &^ mask
+	if (r>>nMantissa)&maxExp == maxExp {
+		r = v &^ mask
+	}
+	return r
+}
@@ -0,0 +1,163 @@
+package fpc
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// noisyFloats makes a smooth series with noise in its low-order bits, which
+// compresses poorly without loss.
+func noisyFloats(n int) []float64 {
+	rng := rand.New(rand.NewSource(1))
+	vals := make([]float64, n)
+	for i := range vals {
+		vals[i] = 280 + 15*math.Sin(float64(i)/500) + rng.Float64()*1e-9
+	}
+	vals[10] = math.Inf(1)
+	vals[11] = math.Inf(-1)
+	vals[12] = math.NaN()
+	vals[13] = math.Copysign(0, -1)
+	vals[14] = math.SmallestNonzeroFloat64
+	vals[15] = -3 * math.SmallestNonzeroFloat64
+	vals[16] = math.MaxFloat64
+	vals[17] = -math.MaxFloat64
+	return vals
+}
+
+func TestLossy(t *testing.T) {
+	want := noisyFloats(3*maxRecordsPerBlock + 7)
+
+	lossless := new(bytes.Buffer)
+	w := NewWriter(lossless)
+	w.WriteFloats(want)
+	w.Close()
+
+	testcases := []struct {
+		opts     WriterOptions
+		maxRatio float64 // largest acceptable size, as a fraction of the lossless size
+		bound    func(want, have float64) bool
+	}{
+		{
+			opts:     WriterOptions{Level: 10, MantissaBits: 20},
+			maxRatio: 0.25,
+			bound: func(want, have float64) bool {
+				// Subnormal values have fewer significant bits, so
+				// dropping mantissa bits loses relative precision.
+				if math.Abs(want) < math.Ldexp(1, -1022) {
+					return math.Abs(have-want) <= math.Ldexp(1, -1022)
+				}
+				return math.Abs(have-want) <= math.Abs(want)*math.Ldexp(1, -21)
+			},
+		},
+		{
+			opts:     WriterOptions{Level: 10, RelativeError: 1e-6},
+			maxRatio: 0.25,
+			bound: func(want, have float64) bool {
+				return math.Abs(have-want) <= math.Abs(want)*1e-6
+			},
+		},
+		{
+			// Absolute bounds zero a varying number of bits, which
+			// can't be rotated away, so they gain less.
+			opts:     WriterOptions{Level: 10, AbsoluteError: 1e-3},
+			maxRatio: 0.6,
+			bound: func(want, have float64) bool {
+				return math.Abs(have-want) <= 1e-3
+			},
+		},
+	}
+	for i, tc := range testcases {
+		buf := new(bytes.Buffer)
+		w, err := NewWriterOptions(buf, tc.opts)
+		if err != nil {
+			t.Fatalf("NewWriterOptions test=%d err=%q", i, err)
+		}
+		w.WriteFloats(want)
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close test=%d err=%q", i, err)
+		}
+		if float64(buf.Len()) > tc.maxRatio*float64(lossless.Len()) {
+			t.Errorf("lossy stream test=%d is %d bytes, want at most %v of %d", i, buf.Len(), tc.maxRatio, lossless.Len())
+		}
+
+		have := make([]float64, len(want))
+		if _, err := NewReader(buf).ReadFloats(have); err != nil {
+			t.Fatalf("ReadFloats test=%d err=%q", i, err)
+		}
+		for j := range want {
+			switch {
+			case math.IsNaN(want[j]):
+				if !math.IsNaN(have[j]) {
+					t.Errorf("value mismatch test=%d idx=%d have=%v want=NaN", i, j, have[j])
+				}
+			case math.IsInf(want[j], 0):
+				if have[j] != want[j] {
+					t.Errorf("value mismatch test=%d idx=%d have=%v want=%v", i, j, have[j], want[j])
+				}
+			case !tc.bound(want[j], have[j]):
+				t.Fatalf("value out of bounds test=%d idx=%d have=%v want=%v", i, j, have[j], want[j])
+			}
+		}
+	}
+}
+
+func TestLossy32(t *testing.T) {
+	want := generateFloat32s(maxRecordsPerBlock)
+	buf := new(bytes.Buffer)
+	w, err := NewWriter32Options(buf, WriterOptions{Level: 10, RelativeError: 1e-3})
+	if err != nil {
+		t.Fatalf("NewWriter32Options err=%q", err)
+	}
+	w.WriteFloat32s(want)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+
+	have := make([]float32, len(want))
+	if _, err := NewReader32(buf).ReadFloat32s(have); err != nil {
+		t.Fatalf("ReadFloat32s err=%q", err)
+	}
+	for i := range want {
+		if math.Abs(float64(have[i])-float64(want[i])) > math.Abs(float64(want[i]))*1e-3 {
+			t.Fatalf("value out of bounds idx=%d have=%v want=%v", i, have[i], want[i])
+		}
+	}
+}
+
+func TestLossyOptionsInvalid(t *testing.T) {
+	for i, opts := range []WriterOptions{
+		{Level: 10, MantissaBits: 53},
+		{Level: 10, MantissaBits: -1},
+		{Level: 10, RelativeError: 1},
+		{Level: 10, RelativeError: math.NaN()},
+		{Level: 10, AbsoluteError: -1},
+		{Level: 10, AbsoluteError: math.Inf(1)},
+		{Level: 10, RelativeError: 1e-3, AbsoluteError: 1e-3},
+	} {
+		if _, err := NewWriterOptions(new(bytes.Buffer), opts); err == nil {
+			t.Errorf("NewWriterOptions test=%d should fail", i)
+		}
+	}
+}
+
+func TestRoundMantissa(t *testing.T) {
+	testcases := []struct {
+		in   float64
+		drop uint
+		want float64
+	}{
+		{in: 1.75, drop: 51, want: 2},
+		{in: 1.25, drop: 51, want: 1.5},
+		{in: -1.75, drop: 52, want: -2},
+		{in: 1.2, drop: 0, want: 1.2},
+		{in: math.MaxFloat64, drop: 10, want: math.Float64frombits(math.Float64bits(math.MaxFloat64) &^ 0x3FF)},
+	}
+	for i, tc := range testcases {
+		have := math.Float64frombits(roundMantissa(math.Float64bits(tc.in), tc.drop, 52, 0x7FF))
+		if have != tc.want {
+			t.Errorf("roundMantissa test=%d have=%v want=%v", i, have, tc.want)
+		}
+	}
+}
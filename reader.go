@@ -4,30 +4,200 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"io/ioutil"
 	"math"
+	"math/bits"
 )
 
 // A DataError is returned when the FPC data is found to be syntactically
-// invalid.
-type DataError string
+// invalid. When the problem is found while decoding a block, the error also
+// says where it lies in the stream.
+type DataError struct {
+	// Msg describes the problem.
+	Msg string
+
+	// Offset is the position in the stream, in bytes, of the invalid data:
+	// the start of the block holding it, or of the record itself if that
+	// is known. It is -1 if unknown.
+	Offset int64
+
+	// Block is the index of the block holding the invalid data, counting
+	// from zero at the start of the stream, or -1 if the problem doesn't
+	// lie within a block. For a SeekableReader, it counts from the start
+	// of the segment being read.
+	Block int
+
+	// Record is the index of the invalid record within its block, or -1 if
+	// the problem doesn't lie with a single record.
+	Record int
+
+	// Want and Have are the expected and actual counts, of bytes or
+	// records as Msg says, when the problem is that they differ. Otherwise
+	// they are both zero.
+	Want, Have int64
+}
+
+// dataError returns a DataError described by msg, whose location is
+// unknown.
+func dataError(msg string) DataError {
+	return DataError{Msg: msg, Offset: -1, Block: -1, Record: -1}
+}
+
+// dataErrorf returns a DataError described by a format string and its
+// arguments, whose location is unknown.
+func dataErrorf(format string, args ...interface{}) DataError {
+	return dataError(fmt.Sprintf(format, args...))
+}
 
 func (e DataError) Error() string {
-	return "fpc data invalid: " + string(e)
+	s := "fpc data invalid: " + e.Msg
+	if e.Offset >= 0 {
+		s += fmt.Sprintf(" at offset %d", e.Offset)
+	}
+	if e.Block >= 0 {
+		s += fmt.Sprintf(" in block %d", e.Block)
+	}
+	if e.Record >= 0 {
+		s += fmt.Sprintf(", record %d", e.Record)
+	}
+	if e.Want != e.Have {
+		s += fmt.Sprintf(" (want %d, have %d)", e.Want, e.Have)
+	}
+	return s
+}
+
+// Is makes errors.Is(err, DataError{}) report whether err is a DataError,
+// whatever its Msg and location.
+func (e DataError) Is(target error) bool {
+	t, ok := target.(DataError)
+	return ok && t.Msg == ""
+}
+
+// A ChecksumError is returned when a block's contents don't match the
+// checksum recorded alongside it, which indicates that the data has been
+// corrupted.
+type ChecksumError struct {
+	// Block is the index of the corrupted block, counting from zero at the
+	// start of the stream. For a SeekableReader, it counts from the start of
+	// the segment being read.
+	Block int
+}
+
+func (e ChecksumError) Error() string {
+	return fmt.Sprintf("fpc data invalid: checksum mismatch in block %d", e.Block)
+}
+
+// Is makes errors.Is(err, DataError{}) report true for a ChecksumError,
+// since a checksum mismatch means the data is invalid too.
+func (e ChecksumError) Is(target error) bool {
+	return DataError{}.Is(target)
+}
+
+// ReaderOptions limit the resources which a Reader will commit to decoding a
+// stream. The compression level recorded in a stream's header determines how
+// much memory is needed to decode it, so these limits protect against
+// untrusted streams which request absurd amounts of memory. They also
+// control how a Reader handles damaged streams.
+type ReaderOptions struct {
+	// MaxLevel is the highest compression level which will be accepted. If
+	// zero, or above MaxCompression, then MaxCompression is used.
+	MaxLevel int
+
+	// MaxMemory is the most memory, in bytes, which may be allocated for
+	// predictor tables. A ParallelReader needs a set of tables for each
+	// of its workers, and the limit covers all of them. If zero, only
+	// MaxLevel applies.
+	MaxMemory int64
+
+	// SkipDamagedSegments makes a Reader recover from damage to segmented
+	// streams, like those made by a ParallelWriter. Each segment starts
+	// with a header giving its length, and its predictors start afresh,
+	// so when a block can't be decoded, the Reader skips the rest of its
+	// segment and carries on from the next one, rather than returning an
+	// error. The lost values are reported by Reader.Skipped. Damage to a
+	// segment's header can't be skipped, and neither can damage to
+	// streams which aren't segmented. Checksums make damage much more
+	// likely to be noticed.
+	SkipDamagedSegments bool
+}
+
+// A SkippedSegment describes values which a Reader lost when it skipped the
+// damaged part of a segment.
+type SkippedSegment struct {
+	// First is the position in the stream of the first lost value,
+	// counting from zero.
+	First int64
+
+	// Values is the number of values lost. They run up to the end of the
+	// segment.
+	Values int64
+
+	// Err is the error which the damage caused.
+	Err error
+}
+
+// check returns a DataError if a stream described by h would exceed the
+// limits set by o, when decoded by the given number of decoders, each with
+// its own predictor tables.
+func (o ReaderOptions) check(h Header, decoders int) error {
+	level := uint(h.Level)
+	maxLevel := o.MaxLevel
+	if maxLevel <= 0 || maxLevel > MaxCompression {
+		maxLevel = MaxCompression
+	}
+	if level < 1 {
+		return dataError("compression level 0 is invalid")
+	}
+	if level > uint(maxLevel) {
+		return dataErrorf("compression level %d exceeds limit of %d", level, maxLevel)
+	}
+	// Each channel of a multi-channel stream has its own tables.
+	if mem := tableMemory(level) * int64(h.channels()) * int64(decoders); o.MaxMemory > 0 && mem > o.MaxMemory {
+		return dataErrorf("compression level %d needs %d bytes of memory, exceeding limit of %d", level, mem, o.MaxMemory)
+	}
+	return nil
+}
+
+// tableMemory returns the number of bytes used by a pair of FCM and DFCM
+// predictor tables at the given compression level.
+func tableMemory(level uint) int64 {
+	return 16 << level
 }
 
 // A Reader provides io.Reader-style access to a stream of FPC
 // compressed data.
 type Reader struct {
-	r io.Reader
+	r    io.Reader
+	opts ReaderOptions
+	elem ElementType // type of values which the caller is reading
 
-	fcm  predictor
-	dfcm predictor
+	fcm   predictor
+	dfcm  predictor
+	extra []predictor // further predictors, used by extended streams
+	clock timestampCoder
+	xform *transformer // undoes the stream's Transform, if it has one
+
+	dict       *Dictionary // dictionary given to NewReaderDict, if any
+	activeDict *Dictionary // dictionary used by the current stream, if any
 
 	initialized bool
 	eof         bool
 
-	block block // Current block being read
+	header       Header // format of the stream, read from its start
+	offset       int64  // bytes of the stream read so far
+	segRemaining int    // bytes left to be read in the current segment
+	segFirst     int64  // position of the current segment's first value
+	segValues    int64  // number of values in the current segment
+	segDecoded   int64  // number of values decoded from the current segment
+
+	skipped []SkippedSegment // damaged segments, for SkipDamagedSegments
+
+	block   block  // Current block being read
+	nBlocks int    // Count of blocks read so far
+	buf     []byte // workspace for reading blocks, reused between them
+	stats   Stats  // Counts of what has been decoded, for Stats
 }
 
 // NewReader creates a new Reader which reads and decompresses FPC data from
@@ -38,29 +208,181 @@ func NewReader(r io.Reader) *Reader {
 	}
 }
 
+// NewReaderOptions creates a new Reader which reads and decompresses FPC data
+// from the given io.Reader, subject to the limits in opts. It reads the
+// stream's header immediately, returning a DataError if the stream exceeds
+// those limits.
+func NewReaderOptions(r io.Reader, opts ReaderOptions) (*Reader, error) {
+	z := &Reader{
+		r:    r,
+		opts: opts,
+	}
+	if err := z.initialize(); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
 func (r *Reader) initialize() (err error) {
-	comp, err := r.readGlobalHeader()
+	h, err := ReadHeader(r.r)
 	if err != nil {
 		return err
 	}
-	tableSize := uint(1 << comp)
-	r.fcm = newFCM(tableSize)
-	r.dfcm = newDFCM(tableSize)
+	r.offset = int64(len(h.encode()))
+	r.stats.CompressedBytes += r.offset
+	return r.initializeHeader(h)
+}
+
+// initializeHeader prepares r to decode a stream described by h.
+func (r *Reader) initializeHeader(h Header) error {
+	if err := r.opts.check(h, 1); err != nil {
+		return err
+	}
+	if h.ElementType.size() != r.elem.size() {
+		return fmt.Errorf("fpc: stream holds %v values, which can't be read as %v", h.ElementType, r.elem)
+	}
+	r.activeDict = nil
+	if h.DictionaryID != 0 {
+		if r.dict == nil || r.dict.ID() != h.DictionaryID {
+			return fmt.Errorf("fpc: stream needs dictionary %08x", h.DictionaryID)
+		}
+		if err := r.dict.check(h); err != nil {
+			return err
+		}
+		r.activeDict = r.dict
+	}
+	if r.fcm != nil && h.Level == r.header.Level && h.ElementType == r.header.ElementType &&
+		h.hashShifts() == r.header.hashShifts() && h.channels() == r.header.channels() &&
+		samePredictors(h, r.header) {
+		// The Reader has been reset, and its tables are the right size.
+		r.resetPredictors()
+	} else {
+		ps, err := newStreamPredictors(h)
+		if err != nil {
+			return err
+		}
+		r.setPredictors(ps)
+		if r.activeDict != nil {
+			r.activeDict.load(r.fcm, r.dfcm)
+		}
+	}
+	r.header = h
+	r.xform = newTransformer(h.Transform, h.ElementType)
 	r.initialized = true
 	return nil
 }
 
-// readGlobalHeader reads one byte and parses it as the compression level.
-func (r *Reader) readGlobalHeader() (comp uint, err error) {
-	var b []byte = make([]byte, 1)
-	n, err := r.r.Read(b)
+// Reset discards the Reader's state and makes it equivalent to the result of
+// its original constructor, but reading from src instead. If the new stream
+// has the same compression level as the old one, Reset reuses the Reader's
+// predictor tables, which makes it much cheaper than making a new Reader.
+func (r *Reader) Reset(src io.Reader) {
+	r.r = src
+	r.initialized = false
+	r.offset = 0
+	r.segRemaining = 0
+	r.segFirst, r.segValues, r.segDecoded = 0, 0, 0
+	r.skipped = nil
+	r.block.reset()
+	r.nBlocks = 0
+	r.stats = Stats{}
+}
+
+// Skipped reports the values which the Reader has lost by skipping damaged
+// segments since it was created or last reset, in the order they were
+// skipped. It is always empty unless the Reader was made with the
+// SkipDamagedSegments option.
+func (r *Reader) Skipped() []SkippedSegment {
+	return r.skipped
+}
+
+// Stats reports what the Reader has decoded since it was created or last
+// reset. Values are counted when their block is decoded, which may be before
+// they have been read.
+func (r *Reader) Stats() Stats {
+	s := r.stats
+	s.UncompressedBytes = s.Values * int64(r.elem.size())
+	return s
+}
+
+// Header returns the header describing the format of the stream, reading it
+// from the underlying io.Reader if necessary.
+func (r *Reader) Header() (Header, error) {
+	if !r.initialized {
+		if err := r.initialize(); err != nil {
+			return Header{}, err
+		}
+	}
+	return r.header, nil
+}
+
+// NewReaderDict creates a new Reader which reads and decompresses FPC data
+// from the given io.Reader. Streams which were written with the dictionary
+// dict are decoded starting from it. Streams which were written without a
+// dictionary can be read too, but streams which were written with a
+// different one can't.
+func NewReaderDict(r io.Reader, dict *Dictionary) *Reader {
+	return &Reader{
+		r:    r,
+		dict: dict,
+	}
+}
+
+// newSegmentReader creates a Reader for decoding the blocks of a single
+// segment of a stream described by h. Segments carry no stream header of
+// their own.
+func newSegmentReader(h Header) (*Reader, error) {
+	if h.DictionaryID != 0 {
+		return nil, errors.New("fpc: segmented streams with dictionaries can only be read by a Reader")
+	}
+	ps, err := newStreamPredictors(h)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	if n != 1 {
-		return 0, DataError("missing first byte compression header")
+	h.Segmented = false
+	z := &Reader{
+		elem:        h.ElementType,
+		header:      h,
+		xform:       newTransformer(h.Transform, h.ElementType),
+		initialized: true,
 	}
-	return uint(b[0]), nil
+	z.setPredictors(ps)
+	return z, nil
+}
+
+// setPredictors makes r decode values using ps, which are ordered as records
+// refer to them.
+func (r *Reader) setPredictors(ps []predictor) {
+	r.fcm, r.dfcm, r.extra = ps[0], ps[1], nil
+	if len(ps) > 2 {
+		r.extra = ps[2:]
+	}
+	r.clock = timestampCoder{}
+}
+
+// resetPredictors returns r's predictors to their initial state: empty, or
+// loaded from the stream's dictionary.
+func (r *Reader) resetPredictors() {
+	r.fcm.reset()
+	r.dfcm.reset()
+	for _, p := range r.extra {
+		p.reset()
+	}
+	if r.activeDict != nil {
+		r.activeDict.load(r.fcm, r.dfcm)
+	}
+	r.clock = timestampCoder{}
+	r.xform.reset()
+}
+
+// resetSegment prepares a Reader made by newSegmentReader to decode a new
+// segment from rd, whose blocks start at the given offset in the stream.
+func (r *Reader) resetSegment(rd io.Reader, offset int64) {
+	r.r = rd
+	r.offset = offset
+	r.block.reset()
+	r.nBlocks = 0
+	r.resetPredictors()
 }
 
 // Read reads from up to (len(buf) / 8) IEEE 754 64-bit floating point
@@ -75,44 +397,84 @@ func (r *Reader) Read(buf []byte) (int, error) {
 	if len(buf)%8 != 0 {
 		return 0, errors.New("fpc: []byte passed to Reader.Read must have length which is a multiple of 8")
 	}
+	return r.read(buf)
+}
 
-	if !r.initialized {
-		err := r.initialize()
+// WriteTo decodes the rest of the stream a block at a time, and writes the
+// values to w as bytes, as Read would return them. It returns the number of
+// bytes written. Reaching the end of the stream isn't an error. It
+// implements io.WriterTo, so io.Copy uses it.
+func (r *Reader) WriteTo(w io.Writer) (int64, error) {
+	width := r.elem.size()
+	buf := make([]byte, width*maxRecordsPerBlock)
+	var n int64
+	for {
+		vals, err := r.values(maxRecordsPerBlock)
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+		b := buf[:r.putValues(buf, vals)]
+		nw, err := w.Write(b)
+		n += int64(nw)
 		if err != nil {
-			return 0, err
+			return n, err
+		} else if nw != len(b) {
+			return n, io.ErrShortWrite
 		}
 	}
+}
 
+// read decodes values into buf, which must have a length which is a
+// multiple of the size of r's element type.
+func (r *Reader) read(buf []byte) (int, error) {
+	width := r.elem.size()
 	nRead := 0
-	for {
-		// If available, read data from the block.
-		n, err := r.readFromBlock(buf)
+	for nRead < len(buf) {
+		vals, err := r.values((len(buf) - nRead) / width)
 		if err != nil {
-			return n, err
-		}
-		nRead += n
-		// We've read everything we need to.
-		if nRead == len(buf) {
-			return nRead, nil
+			return nRead, err
 		}
+		nRead += r.putValues(buf[nRead:], vals)
+	}
+	return nRead, nil
+}
 
-		// End of block.
-		if n == 0 {
-			// Check whether counts match up.
-			if r.block.nRecRead != r.block.nRec {
-				return nRead, DataError("block record length too short")
-			}
-			if r.block.nByteRead != r.block.nByte {
-				return nRead, DataError(fmt.Sprintf("block byte length too short, have=%d  want=%d", r.block.nByteRead, r.block.nByte))
-			}
+// putValues encodes vals into buf as bytes, in the size of r's element type,
+// and returns the number of bytes used.
+func (r *Reader) putValues(buf []byte, vals []uint64) int {
+	width := r.elem.size()
+	for i, v := range vals {
+		if width == 4 {
+			binary.LittleEndian.PutUint32(buf[i*width:], uint32(v))
+		} else {
+			binary.LittleEndian.PutUint64(buf[i*width:], v)
+		}
+	}
+	return width * len(vals)
+}
 
-			// Find a new block
-			r.block, err = r.readBlockHeader()
-			if err != nil {
-				return nRead, err
-			}
+// values returns up to n decoded values from the current block, reading the
+// next block first if the current one has been used up. The returned slice
+// is only valid until the next call to values.
+func (r *Reader) values(n int) ([]uint64, error) {
+	if !r.initialized {
+		if err := r.initialize(); err != nil {
+			return nil, err
 		}
 	}
+	for r.block.pos == len(r.block.vals) {
+		if err := r.nextBlock(); err != nil {
+			return nil, err
+		}
+	}
+	vals := r.block.vals[r.block.pos:]
+	if len(vals) > n {
+		vals = vals[:n]
+	}
+	r.block.pos += len(vals)
+	return vals, nil
 }
 
 // ReadFloats will read data from the underlying io.Reader, parsing
@@ -120,17 +482,58 @@ func (r *Reader) Read(buf []byte) (int, error) {
 // more values are available, ReadFloats will returns with an
 // err==io.EOF.
 func (r *Reader) ReadFloats(fs []float64) (int, error) {
-	buf := make([]byte, 8)
-	var val uint64
-	for i := range fs {
-		_, err := r.Read(buf)
+	if !r.initialized {
+		// Make sure the stream holds float64s before decoding any of it.
+		if err := r.initialize(); err != nil {
+			return 0, err
+		}
+	}
+	nRead := 0
+	for nRead < len(fs) {
+		vals, err := r.values(len(fs) - nRead)
+		if err != nil {
+			return nRead, err
+		}
+		for i, v := range vals {
+			fs[nRead+i] = math.Float64frombits(v)
+		}
+		nRead += len(vals)
+	}
+	return nRead, nil
+}
+
+// ReadUint64s reads values into vs as uint64s, as written by
+// Writer.WriteUint64s, returning the number of values read. Values written
+// as float64s are returned as their bit patterns. If no more values are
+// available, ReadUint64s will return with err==io.EOF.
+func (r *Reader) ReadUint64s(vs []uint64) (int, error) {
+	nRead := 0
+	for nRead < len(vs) {
+		vals, err := r.values(len(vs) - nRead)
+		if err != nil {
+			return nRead, err
+		}
+		nRead += copy(vs[nRead:], vals)
+	}
+	return nRead, nil
+}
+
+// ReadInt64s reads values into vs as int64s, as written by
+// Writer.WriteInt64s, returning the number of values read. If no more values
+// are available, ReadInt64s will return with err==io.EOF.
+func (r *Reader) ReadInt64s(vs []int64) (int, error) {
+	nRead := 0
+	for nRead < len(vs) {
+		vals, err := r.values(len(vs) - nRead)
 		if err != nil {
-			return i, err
+			return nRead, err
+		}
+		for i, v := range vals {
+			vs[nRead+i] = int64(v)
 		}
-		val = binary.LittleEndian.Uint64(buf)
-		fs[i] = math.Float64frombits(val)
+		nRead += len(vals)
 	}
-	return len(fs), nil
+	return nRead, nil
 }
 
 // ReadFloat will read data from the underlying io.Reader until it has
@@ -139,35 +542,181 @@ func (r *Reader) ReadFloats(fs []float64) (int, error) {
 // reading, it returns 0 and that error. If no more values are
 // available, ReadFloat will return with err==io.EOF.
 func (r *Reader) ReadFloat() (float64, error) {
-	buf := make([]byte, 8)
-	_, err := r.Read(buf)
+	vals, err := r.values(1)
 	if err != nil {
 		return 0, err
 	}
-	val := binary.LittleEndian.Uint64(buf)
-	return math.Float64frombits(val), nil
+	return math.Float64frombits(vals[0]), nil
+}
+
+// nextBlock reads and decodes the next block in the stream. For segmented
+// streams, this may involve starting a new segment, which resets the
+// predictors.
+func (r *Reader) nextBlock() error {
+	if r.header.Segmented && r.segRemaining == 0 {
+		if err := r.readSegmentHeader(); err != nil {
+			return err
+		}
+	}
+	r.block.offset = r.offset
+	err := r.locate(r.nextSegmentBlock())
+	if err != nil && r.opts.SkipDamagedSegments && r.header.Segmented {
+		return r.skipSegment(err)
+	}
+	return err
+}
+
+// nextSegmentBlock reads and decodes the next block in the current segment,
+// or in the stream, if it isn't segmented.
+func (r *Reader) nextSegmentBlock() error {
+	nRec, nByte, err := r.readBlockHeader()
+	if err == io.EOF && r.header.Segmented {
+		return dataError("segment too short")
+	} else if err != nil {
+		return err
+	}
+	if r.header.Segmented {
+		// The block header has been read already.
+		r.segRemaining -= blockHeaderSize
+		n := nByte - blockHeaderSize
+		if r.header.Checksums {
+			n += checksumSize
+		}
+		if r.segRemaining < 0 || n < 0 || n > r.segRemaining {
+			return dataError("block overruns its segment")
+		}
+		// readBlock takes the rest of the block from segRemaining once it
+		// has been read. A damaged block may be rejected before then, and
+		// skipSegment needs to know how much of the segment is left.
+	}
+	if err := r.readBlock(nRec, nByte); err != nil {
+		return err
+	}
+	r.nBlocks += 1
+	r.segDecoded += int64(nRec)
+	return nil
+}
+
+// skipSegment handles err, which was returned while decoding a block of the
+// current segment, by recording the loss of the rest of the segment's values
+// and discarding its remaining bytes. It returns nil if the next segment can
+// be read, io.EOF if the stream ended partway through the segment, or err if
+// it can't be skipped.
+func (r *Reader) skipSegment(err error) error {
+	switch err.(type) {
+	case DataError, ChecksumError:
+	default:
+		return err
+	}
+	if r.segRemaining < 0 {
+		// The block header ran past the end of the segment, so the next
+		// segment can't be found.
+		return err
+	}
+	lost := r.segValues - r.segDecoded
+	if lost < 0 {
+		lost = 0
+	}
+	r.skipped = append(r.skipped, SkippedSegment{
+		First:  r.segFirst + r.segDecoded,
+		Values: lost,
+		Err:    err,
+	})
+	r.block.reset()
+	n, err := io.CopyN(ioutil.Discard, r.r, int64(r.segRemaining))
+	r.offset += n
+	// Even if the stream ended early, the segment is finished with, so that
+	// it isn't reported again.
+	r.segRemaining = 0
+	return err
+}
+
+// locate fills in the location of err, if it is a DataError found in the
+// current block whose location isn't yet known.
+func (r *Reader) locate(err error) error {
+	e, ok := err.(DataError)
+	if !ok || e.Offset >= 0 {
+		return err
+	}
+	e.Offset = r.block.offset
+	e.Block = r.nBlocks
+	return e
+}
+
+// readSegmentHeader reads the header of the next segment holding values, and
+// then prepares the predictors to decode that segment.
+func (r *Reader) readSegmentHeader() error {
+	cr := countingReader{r: r.r}
+	nValues, nBytes, err := readSegmentHeader(&cr)
+	r.offset += cr.n
+	if err != nil {
+		return err
+	}
+	r.resetPredictors()
+	r.segRemaining = nBytes
+	r.segFirst += r.segValues
+	r.segValues = int64(nValues)
+	r.segDecoded = 0
+	r.stats.CompressedBytes += segmentHeaderSize
+	return nil
 }
 
-// readBlockHeader reads the block header and record headers that start a data
-// block. It returns the slice of record headers, the number of bytes remaining
-// in the block, and any errors encountered while reading.
-func (r *Reader) readBlockHeader() (b block, err error) {
-	// The first 6 bytes of the block describe the number of records and bytes
-	// in the block.
-	buf := make([]byte, 6)
-	n, err := r.r.Read(buf)
-	if n == 0 && err == io.EOF {
+// readSegmentHeader reads segment headers from r until it finds one for a
+// segment holding values. Segments which hold no values carry metadata, and
+// are skipped.
+func readSegmentHeader(r io.Reader) (nValues, nBytes int, err error) {
+	buf := make([]byte, segmentHeaderSize)
+	for {
+		_, err = io.ReadFull(r, buf)
+		if err == io.EOF {
+			return 0, 0, io.EOF
+		} else if err == io.ErrUnexpectedEOF {
+			return 0, 0, dataError("segment header too short")
+		} else if err != nil {
+			return 0, 0, err
+		}
+		nValues, nBytes = decodeSegmentHeader(buf)
+		if nValues > 0 {
+			return nValues, nBytes, nil
+		}
+		if _, err = io.CopyN(ioutil.Discard, r, int64(nBytes)); err != nil {
+			if err == io.EOF {
+				return 0, 0, dataError("segment too short")
+			}
+			return 0, 0, err
+		}
+	}
+}
+
+// readBlockHeader reads the 6 bytes which start a data block, and which
+// describe the number of records and bytes in the block. It returns io.EOF if
+// there are no blocks left.
+func (r *Reader) readBlockHeader() (nRec, nByte int, err error) {
+	buf := r.workspace(blockHeaderSize)
+	n, err := io.ReadFull(r.r, buf)
+	r.offset += int64(n)
+	if err == io.EOF {
 		// No data available: This is a genuine EOF. We have no blocks left.
-		return b, io.EOF
-	} else if n < len(buf) || err == io.EOF {
+		return 0, 0, io.EOF
+	} else if err == io.ErrUnexpectedEOF {
 		// Partial data available: This is a corrupted header, we expected 6 bytes.
-		return b, DataError("block header too short")
+		e := dataError("block header too short")
+		e.Want, e.Have = blockHeaderSize, int64(n)
+		return 0, 0, e
 	} else if err != nil {
 		// Some other unexpected error
-		return b, err
+		return 0, 0, err
 	}
-	b.nRec, b.nByte = decodeBlockHeader(buf)
-	b.nByteRead += 6 // the first 6 bytes are included in the header's count
+	nRec, nByte = decodeBlockHeader(buf)
+	r.block.crc = crc32.Update(0, crcTable, buf)
+	return nRec, nByte, nil
+}
+
+// readBlock reads the rest of a block whose header described nRec records
+// held in nByte bytes, and decodes all of its values into r.block. The whole
+// block is read at once, so that decoding works from memory.
+func (r *Reader) readBlock(nRec, nByte int) error {
+	width := r.elem.size()
 
 	// Each record has a 4-bit header value. These headers have 1 bit to
 	// describe which predictor hash table to use, and 3 bits to describe how
@@ -176,90 +725,182 @@ func (r *Reader) readBlockHeader() (b block, err error) {
 	// The 4-bit records are packed as pairs into bytes. If there are an odd
 	// number of records in the block, then the last 4-bit header is
 	// meaningless and can be discarded.
-	b.headers = make([]header, b.nRec)
+	//
+	// In extended streams, each record header takes 5 bits instead, with 2
+	// bits to describe the predictor. They are packed together, starting
+	// from the least significant bit of each byte.
+	extended := r.header.Extended
+	rot := int(r.header.Rotation)
+	nHeaderBytes := (nRec + 1) / 2
+	if extended {
+		nHeaderBytes = (5*nRec + 7) / 8
+	}
+	nDataBytes := nByte - blockHeaderSize - nHeaderBytes
+	maxDataBytes := width * nRec
+	if r.header.Timestamps {
+		maxDataBytes += binary.MaxVarintLen64 * nRec
+	}
+	if nDataBytes < 0 || nDataBytes > maxDataBytes {
+		return dataError("block byte length invalid")
+	}
 
-	// Read out the appropriate number of bytes.
-	buf = make([]byte, b.nRec/2)
-	n, err = io.ReadFull(r.r, buf)
+	n := nHeaderBytes + nDataBytes
+	if r.header.Checksums {
+		n += checksumSize
+	}
+	buf := r.workspace(n)
+	nRead, err := io.ReadFull(r.r, buf)
+	r.offset += int64(nRead)
 	if err != nil {
-		return b, err
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			e := dataError("block shorter than its header describes")
+			e.Want, e.Have = int64(nByte), int64(blockHeaderSize+nRead)
+			return e
+		}
+		return err
 	}
-	for i, byte := range buf {
-		b.headers[2*i], b.headers[2*i+1] = decodeHeaders(byte)
+	if r.header.Segmented {
+		r.segRemaining -= n
 	}
-	b.nByteRead += b.nRec / 2
-
-	// If there are an odd number of records, then read just the first 4 bits
-	// of the next byte.
-	if b.nRec%2 == 1 {
-		// Read one byte.
-		buf = buf[:1]
-		_, err = io.ReadFull(r.r, buf)
-		if err != nil {
-			return b, err
+	if r.header.Checksums {
+		// Verify the block before any values are decoded.
+		crc := crc32.Update(r.block.crc, crcTable, buf[:n-checksumSize])
+		if crc != byteOrder.Uint32(buf[n-checksumSize:]) {
+			return ChecksumError{Block: r.nBlocks}
 		}
-		b.headers[b.nRec-1], _ = decodeHeaders(buf[0])
-		b.nByteRead += 1
 	}
 
-	return b, nil
-}
-
-func (r *Reader) readFromBlock(p []byte) (int, error) {
-	var (
-		b    []byte // workspace for decoding
-		val  uint64
-		pred uint64
-		h    header
-
-		bytesDecoded int
-	)
-
-	b = make([]byte, 8) // records can be at most 8 bytes
-	for r.block.nRecRead < r.block.nRec && len(p) > 0 {
-		// Get as many bytes off the reader as the header says we should take.
-		h = r.block.headers[r.block.nRecRead]
-		n, err := r.r.Read(b[:h.len])
-		if n < int(h.len) || err == io.EOF {
-			return bytesDecoded, DataError("missing records")
+	decode := decodeHeaders
+	if width == 4 {
+		decode = decodeHeaders32
+	}
+	if cap(r.block.vals) < nRec {
+		r.block.vals = make([]uint64, nRec)
+	}
+	vals := r.block.vals[:nRec]
+	headers, data := buf[:nHeaderBytes], buf[nHeaderBytes:nHeaderBytes+nDataBytes]
+	var h [2]header
+	for i := range vals {
+		var hdr header
+		if extended {
+			pos := 5 * i
+			bits := uint(headers[pos/8])
+			if pos/8+1 < len(headers) {
+				bits |= uint(headers[pos/8+1]) << 8
+			}
+			hdr = decodeHeaderExtended(byte(bits>>uint(pos%8))&0x1F, width)
+		} else {
+			if i%2 == 0 {
+				h[0], h[1] = decode(headers[i/2])
+			}
+			hdr = h[i%2]
 		}
-		if err != nil {
-			return bytesDecoded, err
+		l := int(hdr.len)
+		if l > width {
+			// The record's header is at fault.
+			pos := i / 2
+			if extended {
+				pos = 5 * i / 8
+			}
+			e := dataError("record longer than its values")
+			e.Offset, e.Block, e.Record = r.block.offset+blockHeaderSize+int64(pos), r.nBlocks, i
+			e.Want, e.Have = int64(width), int64(l)
+			return e
 		}
-
-		// Parse the bytes.
-		val = decodeData(b[:h.len])
+		if l > len(data) {
+			e := dataError("missing records")
+			e.Offset, e.Block, e.Record = r.block.offset+int64(nByte-len(data)), r.nBlocks, i
+			e.Want, e.Have = int64(l), int64(len(data))
+			return e
+		}
+		r.stats.record(hdr)
 
 		// XOR with the predictions to get back the true values.
-		if h.pType == fcmPredictor {
-			pred = r.fcm.predict()
-		} else {
-			pred = r.dfcm.predict()
+		val := decodeData(data[:l])
+		data = data[l:]
+		switch hdr.pType {
+		case fcmPredictor:
+			val ^= r.fcm.predict()
+		case dfcmPredictor:
+			val ^= r.dfcm.predict()
+		default:
+			val ^= r.extra[hdr.pType-2].predict()
 		}
-		val = pred ^ val
 		r.fcm.update(val)
 		r.dfcm.update(val)
+		for _, p := range r.extra {
+			p.update(val)
+		}
+		if r.xform != nil {
+			val = r.xform.undo(val)
+		}
+		if rot != 0 {
+			// Undo the rotation of a lossy stream.
+			if width == 4 {
+				val = uint64(bits.RotateLeft32(uint32(val), rot))
+			} else {
+				val = bits.RotateLeft64(val, rot)
+			}
+		}
+		vals[i] = val
+	}
+	if r.header.Timestamps {
+		if cap(r.block.times) < nRec {
+			r.block.times = make([]int64, nRec)
+		}
+		r.block.times = r.block.times[:nRec]
+		var err error
+		if data, err = r.clock.decode(data, r.block.times); err != nil {
+			return err
+		}
+	}
+	if len(data) != 0 {
+		e := dataError("block byte length doesn't match its records")
+		e.Want, e.Have = int64(nByte-len(data)), int64(nByte)
+		return e
+	}
 
-		// Write the value to p.
-		binary.LittleEndian.PutUint64(p[:8], val)
-		p = p[8:]
+	r.block.vals = vals
+	r.block.pos = 0
+	r.stats.Values += int64(nRec)
+	r.stats.Blocks++
+	r.stats.CompressedBytes += int64(nByte)
+	if r.header.Checksums {
+		r.stats.CompressedBytes += checksumSize
+	}
+	return nil
+}
 
-		// increment counters
-		bytesDecoded += 8
-		r.block.nByteRead += int(h.len)
-		r.block.nRecRead += 1
+// workspace returns a slice of n bytes from r's reusable workspace.
+func (r *Reader) workspace(n int) []byte {
+	if cap(r.buf) < n {
+		r.buf = make([]byte, n)
 	}
-	return bytesDecoded, nil
+	return r.buf[:n]
 }
 
 type block struct {
-	headers []header
+	vals   []uint64 // decoded values, reused between blocks
+	times  []int64  // decoded timestamps, for timestamped streams
+	pos    int      // number of values already returned from vals
+	crc    uint32   // checksum of the block header
+	offset int64    // position of the block in the stream
+}
+
+// reset discards any values which haven't been read from b.
+func (b *block) reset() {
+	b.vals = b.vals[:0]
+	b.pos = 0
+}
 
-	// Counters for current position within the block
-	nRecRead  int
-	nByteRead int
+// A countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
 
-	// Total counts for the block
-	nRec  int
-	nByte int
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
 }
@@ -2,6 +2,10 @@ package fpc
 
 import (
 	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
 	"testing"
 )
 
@@ -20,3 +24,359 @@ func TestReader(t *testing.T) {
 		tc.AssertEqual(t, have, tc.uncompressed, "Reader")
 	}
 }
+
+func TestReaderAcrossBlocks(t *testing.T) {
+	want := generateFloats(2*maxRecordsPerBlock + 3)
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	for _, f := range want {
+		if err := w.WriteFloat(f); err != nil {
+			t.Fatalf("WriteFloat err=%q", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+
+	// A single Read call should be able to span several blocks.
+	r := NewReader(buf)
+	have := make([]byte, 8*len(want))
+	if _, err := r.Read(have); err != nil {
+		t.Fatalf("Read err=%q", err)
+	}
+	for i := range want {
+		if f := math.Float64frombits(byteOrder.Uint64(have[8*i:])); f != want[i] {
+			t.Fatalf("value mismatch idx=%d have=%v want=%v", i, f, want[i])
+		}
+	}
+}
+
+func TestReaderLimits(t *testing.T) {
+	testcases := []struct {
+		in      []byte
+		opts    ReaderOptions
+		wantErr bool
+	}{
+		{in: []byte{0x40}, wantErr: true},
+		{in: []byte{0xFF}, wantErr: true},
+		{in: []byte{MaxCompression + 1}, wantErr: true},
+		{in: []byte{10}, wantErr: false},
+		{in: []byte{10}, opts: ReaderOptions{MaxLevel: 5}, wantErr: true},
+		{in: []byte{5}, opts: ReaderOptions{MaxLevel: 5}, wantErr: false},
+		{in: []byte{10}, opts: ReaderOptions{MaxMemory: 1 << 10}, wantErr: true},
+		{in: []byte{6}, opts: ReaderOptions{MaxMemory: 1 << 10}, wantErr: false},
+		{in: []byte{6 | segmentedFlag}, opts: ReaderOptions{MaxMemory: 1 << 10}, wantErr: false},
+	}
+	for i, tc := range testcases {
+		_, err := NewReaderOptions(bytes.NewReader(tc.in), tc.opts)
+		if tc.wantErr {
+			if _, ok := err.(DataError); !ok {
+				t.Errorf("NewReaderOptions test=%d err=%v, want DataError", i, err)
+			}
+		} else if err != nil {
+			t.Errorf("NewReaderOptions test=%d err=%q", i, err)
+		}
+
+		// The default limits apply to NewReader too.
+		if tc.opts == (ReaderOptions{}) {
+			_, err = NewReader(bytes.NewReader(tc.in)).ReadFloat()
+			if _, ok := err.(DataError); ok != tc.wantErr {
+				t.Errorf("ReadFloat test=%d err=%v, wantErr=%v", i, err, tc.wantErr)
+			}
+		}
+	}
+}
+
+func TestReaderChecksums(t *testing.T) {
+	want := generateFloats(3*maxRecordsPerBlock + 5)
+
+	buf := new(bytes.Buffer)
+	w, err := NewWriterOptions(buf, WriterOptions{Level: DefaultCompression, Checksums: true})
+	if err != nil {
+		t.Fatalf("NewWriterOptions err=%q", err)
+	}
+	for _, f := range want {
+		if err := w.WriteFloat(f); err != nil {
+			t.Fatalf("WriteFloat err=%q", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+	data := buf.Bytes()
+
+	have := make([]float64, len(want))
+	if _, err := NewReader(bytes.NewReader(data)).ReadFloats(have); err != nil {
+		t.Fatalf("ReadFloats err=%q", err)
+	}
+	for i := range want {
+		if have[i] != want[i] {
+			t.Fatalf("value mismatch idx=%d have=%v want=%v", i, have[i], want[i])
+		}
+	}
+
+	// Flip a bit near the end of the stream, which lies in the last block.
+	data[len(data)-10] ^= 0x04
+	_, err = NewReader(bytes.NewReader(data)).ReadFloats(have)
+	if cerr, ok := err.(ChecksumError); !ok {
+		t.Errorf("ReadFloats of corrupted data err=%v, want ChecksumError", err)
+	} else if cerr.Block != 3 {
+		t.Errorf("ChecksumError.Block=%d, want 3", cerr.Block)
+	}
+	if !errors.Is(err, DataError{}) {
+		t.Errorf("errors.Is(%v, DataError{}) = false, want true", err)
+	}
+}
+
+func TestReaderTruncated(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	for _, f := range generateFloats(maxRecordsPerBlock + 100) {
+		w.WriteFloat(f)
+	}
+	w.Close()
+	data := buf.Bytes()
+
+	// Cut the stream at a few points within its second block. Values from
+	// the first block should still be returned.
+	for _, n := range []int{len(data) - 1, len(data) - 40, len(data) - 100} {
+		have := make([]float64, maxRecordsPerBlock+100)
+		nRead, err := NewReader(bytes.NewReader(data[:n])).ReadFloats(have)
+		if _, ok := err.(DataError); !ok {
+			t.Errorf("ReadFloats len=%d err=%v, want DataError", n, err)
+		}
+		if nRead != maxRecordsPerBlock {
+			t.Errorf("ReadFloats len=%d n=%d, want %d", n, nRead, maxRecordsPerBlock)
+		}
+	}
+}
+
+func TestReaderDataErrorLocation(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	for _, f := range generateFloats(maxRecordsPerBlock + 100) {
+		w.WriteFloat(f)
+	}
+	w.Close()
+	data := buf.Bytes()
+	_, nByte := decodeBlockHeader(data[1:])
+	second := 1 + nByte // offset of the second block
+
+	// Cut the stream partway through the second block.
+	_, err := NewReader(bytes.NewReader(data[:len(data)-40])).ReadFloats(make([]float64, maxRecordsPerBlock+100))
+	var derr DataError
+	if !errors.As(err, &derr) || !errors.Is(err, DataError{}) {
+		t.Fatalf("ReadFloats of truncated data err=%v, want DataError", err)
+	}
+	if derr.Offset != int64(second) || derr.Block != 1 || derr.Record != -1 {
+		t.Errorf("truncated data err=%+v, want offset %d in block 1", derr, second)
+	}
+	if derr.Have != derr.Want-40 {
+		t.Errorf("truncated data err=%+v, want 40 bytes missing", derr)
+	}
+
+	// Shorten the first block, so that its last records are missing.
+	short := append([]byte(nil), data...)
+	n := nByte - 10
+	short[4], short[5], short[6] = byte(n), byte(n>>8), byte(n>>16)
+	_, err = NewReader(bytes.NewReader(short)).ReadFloats(make([]float64, maxRecordsPerBlock))
+	derr, ok := err.(DataError)
+	if !ok {
+		t.Fatalf("ReadFloats of shortened block err=%v, want DataError", err)
+	}
+	if derr.Block != 0 || derr.Record < maxRecordsPerBlock-10 || derr.Offset <= 1 || derr.Offset > int64(1+n) {
+		t.Errorf("shortened block err=%+v, want a record near the end of block 0", derr)
+	}
+	if derr.Have >= derr.Want {
+		t.Errorf("shortened block err=%+v, want fewer bytes than needed", derr)
+	}
+}
+
+func TestReaderWriteTo(t *testing.T) {
+	for _, tc := range refTests {
+		have := new(bytes.Buffer)
+		n, err := io.Copy(have, NewReader(bytes.NewReader(tc.compressed)))
+		tc.AssertNoError(t, err, "WriteTo")
+		want := floatBytes(tc.uncompressed)
+		if n != int64(len(want)) {
+			t.Errorf("WriteTo n=%d, want %d", n, len(want))
+		}
+		if !bytes.Equal(have.Bytes(), want) {
+			t.Errorf("WriteTo comp=%v have=%v want=%v", tc.compressed, have.Bytes(), want)
+		}
+	}
+
+	// Errors from the stream are returned, after the values before them.
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.WriteFloats(generateFloats(maxRecordsPerBlock + 100))
+	w.Close()
+	data := buf.Bytes()
+	n, err := NewReader(bytes.NewReader(data[:len(data)-10])).WriteTo(ioutil.Discard)
+	if _, ok := err.(DataError); !ok {
+		t.Errorf("WriteTo of truncated data err=%v, want DataError", err)
+	}
+	if n != 8*maxRecordsPerBlock {
+		t.Errorf("WriteTo of truncated data n=%d, want %d", n, 8*maxRecordsPerBlock)
+	}
+}
+
+func TestReaderReset(t *testing.T) {
+	r := NewReader(nil)
+	for _, tc := range refTests {
+		// Reuse the Reader partway through a stream, and across streams
+		// with different compression levels.
+		r.Reset(bytes.NewReader(tc.compressed))
+		have := make([]float64, len(tc.uncompressed))
+		_, err := r.ReadFloats(have)
+		tc.AssertNoError(t, err, "ReadFloats")
+		tc.AssertEqual(t, have, tc.uncompressed, "Reader")
+	}
+
+	// Abandon each stream halfway through, and check that the stream after
+	// it still decodes from its start.
+	for i, tc := range refTests {
+		r.Reset(bytes.NewReader(tc.compressed))
+		if half := len(tc.uncompressed) / 2; half > 0 {
+			_, err := r.ReadFloats(make([]float64, half))
+			tc.AssertNoError(t, err, "partial ReadFloats")
+		}
+		next := refTests[(i+1)%len(refTests)]
+		r.Reset(bytes.NewReader(next.compressed))
+		have := make([]float64, len(next.uncompressed))
+		_, err := r.ReadFloats(have)
+		next.AssertNoError(t, err, "ReadFloats after partial read")
+		next.AssertEqual(t, have, next.uncompressed, "Reader after partial read")
+	}
+}
+
+func TestReaderSkipDamagedSegments(t *testing.T) {
+	// Write three segments, each of two blocks, noting where each one ends.
+	const segSize = maxRecordsPerBlock + 100
+	want := generateFloats(3 * segSize)
+	buf := new(bytes.Buffer)
+	w, err := NewParallelWriterOptions(buf, WriterOptions{Level: DefaultCompression, Checksums: true}, 2)
+	if err != nil {
+		t.Fatalf("NewParallelWriterOptions err=%q", err)
+	}
+	var ends []int
+	for i, f := range want {
+		if err := w.WriteFloat(f); err != nil {
+			t.Fatalf("WriteFloat err=%q", err)
+		}
+		if (i+1)%segSize == 0 {
+			if err := w.Flush(); err != nil {
+				t.Fatalf("Flush err=%q", err)
+			}
+			ends = append(ends, buf.Len())
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+	data := buf.Bytes()
+
+	// Flip a bit in the last block of the second segment.
+	data[ends[1]-10] ^= 0x04
+	if _, err := NewReader(bytes.NewReader(data)).ReadFloats(make([]float64, len(want))); err == nil {
+		t.Fatalf("ReadFloats of corrupted data should fail")
+	}
+
+	opts := ReaderOptions{SkipDamagedSegments: true}
+	r, err := NewReaderOptions(bytes.NewReader(data), opts)
+	if err != nil {
+		t.Fatalf("NewReaderOptions err=%q", err)
+	}
+	have := make([]float64, len(want))
+	n, err := r.ReadFloats(have)
+	if err != io.EOF {
+		t.Fatalf("ReadFloats err=%v, want io.EOF", err)
+	}
+	// The values of the damaged block are lost, but the rest of the
+	// stream survives.
+	first := segSize + maxRecordsPerBlock
+	kept := append(append([]float64(nil), want[:first]...), want[2*segSize:]...)
+	if n != len(kept) {
+		t.Fatalf("ReadFloats n=%d, want %d", n, len(kept))
+	}
+	for i := range kept {
+		if have[i] != kept[i] {
+			t.Fatalf("value mismatch idx=%d have=%v want=%v", i, have[i], kept[i])
+		}
+	}
+	skipped := r.Skipped()
+	if len(skipped) != 1 || skipped[0].First != int64(first) || skipped[0].Values != 100 {
+		t.Fatalf("Skipped()=%+v, want 100 values from %d", skipped, first)
+	}
+	if _, ok := skipped[0].Err.(ChecksumError); !ok {
+		t.Errorf("Skipped()[0].Err=%v, want ChecksumError", skipped[0].Err)
+	}
+
+	// A stream which ends partway through a segment loses the rest of it.
+	r.Reset(bytes.NewReader(data[:ends[1]+100]))
+	n, err = r.ReadFloats(have)
+	if err != io.EOF {
+		t.Fatalf("ReadFloats of truncated data err=%v, want io.EOF", err)
+	}
+	if n != len(kept)-segSize {
+		t.Errorf("ReadFloats of truncated data n=%d, want %d", n, len(kept)-segSize)
+	}
+	skipped = r.Skipped()
+	if len(skipped) != 2 || skipped[1].First != 2*segSize || skipped[1].Values != segSize {
+		t.Errorf("Skipped()=%+v, want %d values from %d", skipped, segSize, 2*segSize)
+	}
+}
+
+func TestReaderSkipDamagedBlockHeader(t *testing.T) {
+	const segSize = maxRecordsPerBlock + 100
+	want := generateFloats(3 * segSize)
+	buf := new(bytes.Buffer)
+	w, err := NewParallelWriterOptions(buf, WriterOptions{Level: DefaultCompression, Checksums: true}, 2)
+	if err != nil {
+		t.Fatalf("NewParallelWriterOptions err=%q", err)
+	}
+	var ends []int
+	for i := 0; i < len(want); i += segSize {
+		for _, f := range want[i : i+segSize] {
+			if err := w.WriteFloat(f); err != nil {
+				t.Fatalf("WriteFloat err=%q", err)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatalf("Flush err=%q", err)
+		}
+		ends = append(ends, buf.Len())
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+	data := buf.Bytes()
+
+	// Inflate the record count of the second segment's first block, so
+	// that the block is rejected before its body is read.
+	data[ends[0]+segmentHeaderSize+2] ^= 0x80
+
+	r, err := NewReaderOptions(bytes.NewReader(data), ReaderOptions{SkipDamagedSegments: true})
+	if err != nil {
+		t.Fatalf("NewReaderOptions err=%q", err)
+	}
+	have := make([]float64, len(want))
+	n, err := r.ReadFloats(have)
+	if err != io.EOF {
+		t.Fatalf("ReadFloats err=%v, want io.EOF", err)
+	}
+	kept := append(append([]float64(nil), want[:segSize]...), want[2*segSize:]...)
+	if n != len(kept) {
+		t.Fatalf("ReadFloats n=%d, want %d", n, len(kept))
+	}
+	for i := range kept {
+		if have[i] != kept[i] {
+			t.Fatalf("value mismatch idx=%d have=%v want=%v", i, have[i], kept[i])
+		}
+	}
+	skipped := r.Skipped()
+	if len(skipped) != 1 || skipped[0].First != segSize || skipped[0].Values != segSize {
+		t.Errorf("Skipped()=%+v, want %d values from %d", skipped, segSize, segSize)
+	}
+}
@@ -0,0 +1,268 @@
+package fpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// A SeekableReader provides random access to the values in a stream of FPC
+// compressed data which ends with a segment index, like those written by a
+// ParallelWriter.
+//
+// A SeekableReader decodes whole segments at a time, and keeps the most
+// recently decoded segment in memory, so reads which are close together are
+// cheap. It is not safe for concurrent use.
+type SeekableReader struct {
+	r     io.ReaderAt
+	index []indexEntry
+	end   int64 // offset of the index segment, which follows the last segment
+	total int64 // number of values in the stream
+
+	dec *Reader  // decoder for individual segments
+	seg int      // position in the index of the segment in buf, or -1
+	buf *segment // most recently decoded segment
+
+	pos int64 // ordinal of the next value for Read and ReadFloats
+}
+
+// NewSeekableReader creates a new SeekableReader which reads and decompresses
+// FPC data from r, which holds size bytes. It returns an error if the data
+// doesn't end with a segment index.
+func NewSeekableReader(r io.ReaderAt, size int64) (*SeekableReader, error) {
+	return NewSeekableReaderOptions(r, size, ReaderOptions{})
+}
+
+// NewSeekableReaderOptions is like NewSeekableReader, but it also returns a
+// DataError if the stream exceeds the limits in opts.
+func NewSeekableReaderOptions(r io.ReaderAt, size int64, opts ReaderOptions) (*SeekableReader, error) {
+	h, err := ReadHeader(io.NewSectionReader(r, 0, size))
+	if err == io.EOF {
+		return nil, dataError("missing first byte compression header")
+	} else if err != nil {
+		return nil, err
+	}
+	if !h.Indexed {
+		return nil, dataError("stream has no segment index")
+	}
+	if err := opts.check(h, 1); err != nil {
+		return nil, err
+	}
+	if h.ElementType.size() != 8 {
+		return nil, fmt.Errorf("fpc: stream holds %v values, which can't be read as float64", h.ElementType)
+	}
+
+	start := int64(len(h.encode())) // offset of the first segment
+	if size < start+segmentHeaderSize+indexFooterSize {
+		return nil, dataError("missing segment index")
+	}
+	footer := make([]byte, indexFooterSize)
+	if _, err := r.ReadAt(footer, size-indexFooterSize); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(footer[4:], indexMagic) {
+		return nil, dataError("missing segment index")
+	}
+	nBytes := int64(byteOrder.Uint32(footer[0:4]))
+	end := size - nBytes - segmentHeaderSize
+	if end < start {
+		return nil, dataError("malformed segment index")
+	}
+	buf := make([]byte, segmentHeaderSize+nBytes)
+	if _, err := r.ReadAt(buf, end); err != nil {
+		return nil, err
+	}
+	if nValues, n := decodeSegmentHeader(buf); nValues != 0 || int64(n) != nBytes {
+		return nil, dataError("malformed segment index")
+	}
+	index, total, err := decodeIndex(buf[segmentHeaderSize:])
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure the index is consistent, so that lookups can trust it.
+	for i, e := range index {
+		next := indexEntry{offset: end, first: total}
+		if i+1 < len(index) {
+			next = index[i+1]
+		}
+		if e.offset < start || e.offset+segmentHeaderSize > next.offset || e.first >= next.first {
+			return nil, dataError("malformed segment index")
+		}
+	}
+
+	dec, err := newSegmentReader(h)
+	if err != nil {
+		return nil, err
+	}
+	z := &SeekableReader{
+		r:     r,
+		index: index,
+		end:   end,
+		total: total,
+		dec:   dec,
+		seg:   -1,
+	}
+	return z, nil
+}
+
+// Len returns the number of values in the stream.
+func (r *SeekableReader) Len() int64 {
+	return r.total
+}
+
+// ReadFloatsAt reads len(fs) values into fs, starting with the value whose
+// ordinal within the stream is idx. It returns the number of values read. If
+// fewer than len(fs) values are read, it also returns an error explaining
+// why; at the end of the stream, that error is io.EOF.
+func (r *SeekableReader) ReadFloatsAt(fs []float64, idx int64) (int, error) {
+	if idx < 0 {
+		return 0, errors.New("fpc.SeekableReader.ReadFloatsAt: negative position")
+	}
+	nRead := 0
+	for nRead < len(fs) {
+		vals, err := r.valuesAt(idx + int64(nRead))
+		if err != nil {
+			return nRead, err
+		}
+		for _, v := range vals {
+			if nRead == len(fs) {
+				break
+			}
+			fs[nRead] = math.Float64frombits(v)
+			nRead++
+		}
+	}
+	return nRead, nil
+}
+
+// Read reads from up to (len(buf) / 8) IEEE 754 64-bit floating point
+// values into buf, starting at the current position of r. It is an error to
+// provide a buf whose length is not a multiple of 8, because that would
+// prevent encoding of the read float64s.
+//
+// If more values might be available, Read will return len(buf),
+// nil. If no more values are available, Read will return with
+// err==io.EOF
+func (r *SeekableReader) Read(buf []byte) (int, error) {
+	if len(buf)%8 != 0 {
+		return 0, errors.New("fpc: []byte passed to SeekableReader.Read must have length which is a multiple of 8")
+	}
+	nRead := 0
+	for nRead < len(buf) {
+		vals, err := r.valuesAt(r.pos)
+		if err != nil {
+			return nRead, err
+		}
+		for _, v := range vals {
+			if nRead == len(buf) {
+				break
+			}
+			binary.LittleEndian.PutUint64(buf[nRead:], v)
+			nRead += 8
+			r.pos++
+		}
+	}
+	return nRead, nil
+}
+
+// ReadFloats reads values into fs, starting at the current position of
+// r. If no more values are available, ReadFloats will returns with an
+// err==io.EOF.
+func (r *SeekableReader) ReadFloats(fs []float64) (int, error) {
+	n, err := r.ReadFloatsAt(fs, r.pos)
+	r.pos += int64(n)
+	return n, err
+}
+
+// Seek sets the position for the next Read or ReadFloats call. Like Read, it
+// works in terms of uncompressed bytes: each value is 8 bytes long, and offset
+// must be a multiple of 8. Seek implements io.Seeker.
+func (r *SeekableReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = 8*r.pos + offset
+	case io.SeekEnd:
+		abs = 8*r.total + offset
+	default:
+		return 0, errors.New("fpc.SeekableReader.Seek: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("fpc.SeekableReader.Seek: negative position")
+	}
+	if abs%8 != 0 {
+		return 0, errors.New("fpc.SeekableReader.Seek: position must be a multiple of 8")
+	}
+	r.pos = abs / 8
+	return abs, nil
+}
+
+// valuesAt returns the decoded values from idx up to the end of the segment
+// which holds idx.
+func (r *SeekableReader) valuesAt(idx int64) ([]uint64, error) {
+	if idx >= r.total {
+		return nil, io.EOF
+	}
+	seg := sort.Search(len(r.index), func(i int) bool {
+		return r.index[i].first > idx
+	}) - 1
+	if seg < 0 {
+		return nil, dataError("segment index doesn't cover value")
+	}
+	if seg != r.seg {
+		if err := r.loadSegment(seg); err != nil {
+			return nil, err
+		}
+	}
+	return r.buf.vals[idx-r.index[seg].first:], nil
+}
+
+// loadSegment reads and decodes the segment at position seg in the index.
+func (r *SeekableReader) loadSegment(seg int) error {
+	r.seg = -1
+	start, end := r.index[seg].offset, r.end
+	nValues := r.total - r.index[seg].first
+	if seg+1 < len(r.index) {
+		end = r.index[seg+1].offset
+		nValues = r.index[seg+1].first - r.index[seg].first
+	}
+
+	sr := io.NewSectionReader(r.r, start, end-start)
+	n, nBytes, err := readSegmentHeader(sr)
+	if err == io.EOF {
+		return dataError("segment too short")
+	} else if err != nil {
+		return err
+	}
+	if int64(n) != nValues || int64(nBytes) != end-start-segmentHeaderSize {
+		return dataError("segment header doesn't match segment index")
+	}
+	if n > 2*nBytes {
+		return dataError("segment value count too large")
+	}
+
+	if r.buf == nil {
+		r.buf = new(segment)
+	}
+	r.buf.data.Reset()
+	r.buf.offset = start + segmentHeaderSize
+	if _, err := r.buf.data.ReadFrom(sr); err != nil {
+		return err
+	}
+	if cap(r.buf.vals) < n {
+		r.buf.vals = make([]uint64, n)
+	}
+	r.buf.vals = r.buf.vals[:n]
+	if err := decodeSegment(r.dec, r.buf); err != nil {
+		return err
+	}
+	r.seg = seg
+	return nil
+}
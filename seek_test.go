@@ -0,0 +1,130 @@
+package fpc
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func writeParallel(t *testing.T, vals []float64) []byte {
+	buf := new(bytes.Buffer)
+	w, err := NewParallelWriter(buf, DefaultCompression, 2)
+	if err != nil {
+		t.Fatalf("NewParallelWriter err=%q", err)
+	}
+	for _, f := range vals {
+		if err := w.WriteFloat(f); err != nil {
+			t.Fatalf("WriteFloat err=%q", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+	return buf.Bytes()
+}
+
+func TestSeekableReaderReadFloatsAt(t *testing.T) {
+	want := generateFloats(3*DefaultSegmentSize + 10)
+	data := writeParallel(t, want)
+
+	r, err := NewSeekableReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("NewSeekableReader err=%q", err)
+	}
+	if r.Len() != int64(len(want)) {
+		t.Fatalf("Len=%d, want %d", r.Len(), len(want))
+	}
+
+	testcases := []struct {
+		idx int64
+		n   int
+	}{
+		{idx: 0, n: 10},
+		{idx: 2*DefaultSegmentSize + 5, n: 100},
+		{idx: DefaultSegmentSize - 3, n: 6}, // spans segments
+		{idx: 5, n: 1},
+		{idx: int64(len(want)) - 4, n: 4},
+	}
+	for i, tc := range testcases {
+		have := make([]float64, tc.n)
+		n, err := r.ReadFloatsAt(have, tc.idx)
+		if err != nil {
+			t.Fatalf("ReadFloatsAt test=%d err=%q", i, err)
+		}
+		if n != tc.n {
+			t.Fatalf("ReadFloatsAt test=%d n=%d, want %d", i, n, tc.n)
+		}
+		for j := range have {
+			if have[j] != want[tc.idx+int64(j)] {
+				t.Fatalf("value mismatch test=%d idx=%d have=%v want=%v", i, tc.idx+int64(j), have[j], want[tc.idx+int64(j)])
+			}
+		}
+	}
+
+	// Reading past the end is an EOF.
+	have := make([]float64, 10)
+	n, err := r.ReadFloatsAt(have, int64(len(want))-5)
+	if n != 5 || err != io.EOF {
+		t.Errorf("ReadFloatsAt at end n=%d err=%v, want 5, io.EOF", n, err)
+	}
+}
+
+func TestSeekableReaderSeek(t *testing.T) {
+	want := generateFloats(DefaultSegmentSize + 10)
+	data := writeParallel(t, want)
+
+	r, err := NewSeekableReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("NewSeekableReader err=%q", err)
+	}
+	pos, err := r.Seek(-8*20, io.SeekEnd)
+	if err != nil {
+		t.Fatalf("Seek err=%q", err)
+	}
+	if pos != 8*int64(len(want)-20) {
+		t.Errorf("Seek pos=%d, want %d", pos, 8*(len(want)-20))
+	}
+	have := make([]float64, 20)
+	if _, err := r.ReadFloats(have); err != nil {
+		t.Fatalf("ReadFloats err=%q", err)
+	}
+	for j := range have {
+		if have[j] != want[len(want)-20+j] {
+			t.Fatalf("value mismatch idx=%d have=%v want=%v", j, have[j], want[len(want)-20+j])
+		}
+	}
+	if _, err := r.ReadFloats(have); err != io.EOF {
+		t.Errorf("ReadFloats at end err=%v, want io.EOF", err)
+	}
+
+	if _, err := r.Seek(3, io.SeekStart); err == nil {
+		t.Errorf("Seek to unaligned position should fail")
+	}
+}
+
+func TestSeekableReaderUnindexed(t *testing.T) {
+	for _, tc := range refTests {
+		_, err := NewSeekableReader(bytes.NewReader(tc.compressed), int64(len(tc.compressed)))
+		if _, ok := err.(DataError); !ok {
+			t.Errorf("NewSeekableReader test=%d err=%v, want DataError", tc.idx(), err)
+		}
+	}
+}
+
+func TestSeekableReaderLimits(t *testing.T) {
+	data := writeParallel(t, generateFloats(100))
+	for _, tc := range []struct {
+		opts    ReaderOptions
+		wantErr bool
+	}{
+		{opts: ReaderOptions{}, wantErr: false},
+		{opts: ReaderOptions{MaxLevel: DefaultCompression}, wantErr: false},
+		{opts: ReaderOptions{MaxLevel: DefaultCompression - 1}, wantErr: true},
+		{opts: ReaderOptions{MaxMemory: 1 << 10}, wantErr: true},
+	} {
+		_, err := NewSeekableReaderOptions(bytes.NewReader(data), int64(len(data)), tc.opts)
+		if _, ok := err.(DataError); ok != tc.wantErr {
+			t.Errorf("NewSeekableReaderOptions opts=%+v err=%v, wantErr=%v", tc.opts, err, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package fpc
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// A SeriesWriter FPC-compresses a time series: float64 values, each paired
+// with an int64 timestamp, in any unit. Timestamps are stored alongside the
+// values in the same blocks, as the change in the interval between each
+// timestamp and the one before it, so regularly spaced timestamps take a
+// single byte each.
+//
+// Streams written by a SeriesWriter always have a framed header, which
+// records that they hold timestamps. They should be read with a
+// SeriesReader, although a Reader can read their values, ignoring the
+// timestamps.
+type SeriesWriter struct {
+	w *Writer
+}
+
+// NewSeriesWriter makes a new SeriesWriter which writes compressed data to w
+// using a provided compression level. It returns an error if an invalid
+// compression level is provided.
+func NewSeriesWriter(w io.Writer, level int) (*SeriesWriter, error) {
+	return NewSeriesWriterOptions(w, WriterOptions{Level: level})
+}
+
+// NewSeriesWriterOptions makes a new SeriesWriter which writes compressed
+// data to w in the format described by opts. It returns an error if opts
+// are invalid.
+func NewSeriesWriterOptions(w io.Writer, opts WriterOptions) (*SeriesWriter, error) {
+	opts.timestamps = true
+	z, err := newWriter(w, opts, Float64)
+	if err != nil {
+		return nil, err
+	}
+	return &SeriesWriter{w: z}, nil
+}
+
+// WritePoint writes a single value and its timestamp to the encoded stream.
+func (w *SeriesWriter) WritePoint(t int64, f float64) error {
+	if err := w.w.ensureHeader(); err != nil {
+		return err
+	}
+	return w.w.enc.encodePoint(t, math.Float64bits(f))
+}
+
+// WritePoints writes values and their timestamps to the encoded stream,
+// pairing each value in fs with the timestamp at the same position in ts. It
+// returns the number of points written, and an error if ts and fs have
+// different lengths.
+func (w *SeriesWriter) WritePoints(ts []int64, fs []float64) (int, error) {
+	if len(ts) != len(fs) {
+		return 0, fmt.Errorf("fpc.WritePoints: %d timestamps for %d values", len(ts), len(fs))
+	}
+	if err := w.w.ensureHeader(); err != nil {
+		return 0, err
+	}
+	for i, f := range fs {
+		if err := w.w.enc.encodePoint(ts[i], math.Float64bits(f)); err != nil {
+			return i, err
+		}
+	}
+	return len(fs), nil
+}
+
+// Flush will make sure all internally-buffered points are written to the
+// underlying io.Writer, even if it results in a partial block. It does not
+// flush the underlying io.Writer.
+func (w *SeriesWriter) Flush() error {
+	return w.w.Flush()
+}
+
+// Close will flush the SeriesWriter and make any subsequent writes return
+// errors. It does not close the underlying io.Writer.
+func (w *SeriesWriter) Close() error {
+	return w.w.Close()
+}
+
+// Reset discards the SeriesWriter's state and makes it equivalent to the
+// result of its original constructor, but writing to dst instead, reusing
+// its predictor tables.
+func (w *SeriesWriter) Reset(dst io.Writer) {
+	w.w.Reset(dst)
+}
+
+// Stats reports what the SeriesWriter has compressed since it was created or
+// last reset. The uncompressed size of each point includes 8 bytes for its
+// timestamp.
+func (w *SeriesWriter) Stats() Stats {
+	s := w.w.Stats()
+	s.UncompressedBytes += 8 * s.Values
+	return s
+}
+
+// A SeriesReader reads a time series from a stream written by a
+// SeriesWriter.
+type SeriesReader struct {
+	r *Reader
+}
+
+// NewSeriesReader creates a new SeriesReader which reads and decompresses
+// FPC data from the given io.Reader.
+func NewSeriesReader(r io.Reader) *SeriesReader {
+	return &SeriesReader{r: NewReader(r)}
+}
+
+// ReadPoints reads points into ts and fs, putting each timestamp in ts and
+// its value at the same position in fs. It reads up to len(fs) points, and
+// returns the number read. If no more points are available, ReadPoints
+// returns with err==io.EOF. It returns an error if ts and fs have different
+// lengths, and a DataError if the stream has no timestamps.
+func (r *SeriesReader) ReadPoints(ts []int64, fs []float64) (int, error) {
+	if len(ts) != len(fs) {
+		return 0, fmt.Errorf("fpc.ReadPoints: %d timestamps for %d values", len(ts), len(fs))
+	}
+	h, err := r.r.Header()
+	if err != nil {
+		return 0, err
+	}
+	if !h.Timestamps {
+		return 0, dataError("stream has no timestamps")
+	}
+	nRead := 0
+	for nRead < len(fs) {
+		vals, err := r.r.values(len(fs) - nRead)
+		if err != nil {
+			return nRead, err
+		}
+		end := r.r.block.pos
+		copy(ts[nRead:], r.r.block.times[end-len(vals):end])
+		for i, v := range vals {
+			fs[nRead+i] = math.Float64frombits(v)
+		}
+		nRead += len(vals)
+	}
+	return nRead, nil
+}
+
+// ReadPoint reads the next point from the stream, returning its timestamp
+// and value. If no more points are available, ReadPoint returns with
+// err==io.EOF.
+func (r *SeriesReader) ReadPoint() (int64, float64, error) {
+	var ts [1]int64
+	var fs [1]float64
+	if _, err := r.ReadPoints(ts[:], fs[:]); err != nil {
+		return 0, 0, err
+	}
+	return ts[0], fs[0], nil
+}
+
+// Header returns the header describing the format of the stream, reading it
+// from the underlying io.Reader if necessary.
+func (r *SeriesReader) Header() (Header, error) {
+	return r.r.Header()
+}
+
+// Reset discards the SeriesReader's state and makes it equivalent to the
+// result of its original constructor, but reading from src instead, reusing
+// its predictor tables where possible.
+func (r *SeriesReader) Reset(src io.Reader) {
+	r.r.Reset(src)
+}
+
+// Stats reports what the SeriesReader has decoded since it was created or
+// last reset. The uncompressed size of each point includes 8 bytes for its
+// timestamp.
+func (r *SeriesReader) Stats() Stats {
+	s := r.r.Stats()
+	s.UncompressedBytes += 8 * s.Values
+	return s
+}
@@ -0,0 +1,181 @@
+package fpc
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"testing"
+)
+
+// generateSeries makes n points, one a second with occasional jitter and
+// gaps, in nanoseconds.
+func generateSeries(n int) ([]int64, []float64) {
+	ts := make([]int64, n)
+	fs := make([]float64, n)
+	t := int64(1500000000e9)
+	for i := range ts {
+		t += 1e9
+		if i%100 == 0 {
+			t += int64(i%7) * 1e6
+		}
+		if i%5000 == 0 {
+			t += 3600e9
+		}
+		ts[i] = t
+		fs[i] = 20 + math.Sin(float64(i)/500)
+	}
+	return ts, fs
+}
+
+func TestSeries(t *testing.T) {
+	wantTs, wantFs := generateSeries(2*maxRecordsPerBlock + 101)
+
+	buf := new(bytes.Buffer)
+	w, err := NewSeriesWriterOptions(buf, WriterOptions{Level: 12, Checksums: true})
+	if err != nil {
+		t.Fatalf("NewSeriesWriterOptions err=%q", err)
+	}
+	if err := w.WritePoint(wantTs[0], wantFs[0]); err != nil {
+		t.Fatalf("WritePoint err=%q", err)
+	}
+	// Flushing after an odd number of points leaves a block whose last
+	// record has no partner.
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush err=%q", err)
+	}
+	if n, err := w.WritePoints(wantTs[1:], wantFs[1:]); err != nil || n != len(wantTs)-1 {
+		t.Fatalf("WritePoints n=%d err=%v", n, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+
+	// Regular timestamps should take little more than a byte each.
+	values := new(bytes.Buffer)
+	vw, err := NewWriterOptions(values, WriterOptions{Level: 12, Checksums: true, Framed: true})
+	if err != nil {
+		t.Fatalf("NewWriterOptions err=%q", err)
+	}
+	vw.WriteFloats(wantFs)
+	vw.Close()
+	if extra := buf.Len() - values.Len(); extra > len(wantTs)*11/10 {
+		t.Errorf("timestamps take %d bytes, want about %d", extra, len(wantTs))
+	}
+
+	r := NewSeriesReader(bytes.NewReader(buf.Bytes()))
+	haveTs := make([]int64, len(wantTs)+1)
+	haveFs := make([]float64, len(wantFs)+1)
+	n, err := r.ReadPoints(haveTs, haveFs)
+	if err != io.EOF {
+		t.Errorf("ReadPoints err=%v, want io.EOF", err)
+	}
+	if n != len(wantTs) {
+		t.Fatalf("ReadPoints n=%d, want %d", n, len(wantTs))
+	}
+	for i := range wantTs {
+		if haveTs[i] != wantTs[i] || haveFs[i] != wantFs[i] {
+			t.Fatalf("point mismatch idx=%d have=(%d, %v) want=(%d, %v)", i, haveTs[i], haveFs[i], wantTs[i], wantFs[i])
+		}
+	}
+	if rs, ws := r.Stats(), w.Stats(); rs != ws {
+		t.Errorf("Stats mismatch\nreader %+v\nwriter %+v", rs, ws)
+	}
+
+	// A Reader can read the values alone.
+	have := make([]float64, len(wantFs))
+	if _, err := NewReader(bytes.NewReader(buf.Bytes())).ReadFloats(have); err != nil {
+		t.Fatalf("ReadFloats err=%q", err)
+	}
+	for i := range wantFs {
+		if have[i] != wantFs[i] {
+			t.Fatalf("value mismatch idx=%d have=%v want=%v", i, have[i], wantFs[i])
+		}
+	}
+}
+
+func TestSeriesReset(t *testing.T) {
+	w, err := NewSeriesWriter(new(bytes.Buffer), 4)
+	if err != nil {
+		t.Fatalf("NewSeriesWriter err=%q", err)
+	}
+	w.WritePoint(100, 1)
+	w.Close()
+
+	buf := new(bytes.Buffer)
+	w.Reset(buf)
+	w.WritePoint(5, 2)
+	w.WritePoint(7, 3)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+
+	r := NewSeriesReader(buf)
+	for _, want := range []struct {
+		t int64
+		f float64
+	}{{5, 2}, {7, 3}} {
+		ts, f, err := r.ReadPoint()
+		if err != nil {
+			t.Fatalf("ReadPoint err=%q", err)
+		}
+		if ts != want.t || f != want.f {
+			t.Errorf("ReadPoint=(%d, %v), want (%d, %v)", ts, f, want.t, want.f)
+		}
+	}
+	if _, _, err := r.ReadPoint(); err != io.EOF {
+		t.Errorf("ReadPoint at end err=%v, want io.EOF", err)
+	}
+}
+
+func TestSeriesReaderInvalid(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.WriteFloat(1)
+	w.Close()
+	_, _, err := NewSeriesReader(buf).ReadPoint()
+	if _, ok := err.(DataError); !ok {
+		t.Errorf("ReadPoint of a stream without timestamps err=%v, want DataError", err)
+	}
+
+	// Drop the last timestamp's byte, and fix up the block's length.
+	buf.Reset()
+	sw, _ := NewSeriesWriter(buf, 4)
+	sw.WritePoint(1, 1)
+	sw.WritePoint(2, 2)
+	sw.Close()
+	data := buf.Bytes()[:buf.Len()-1]
+	data[framedHeaderSize+3]--
+	_, _, err = NewSeriesReader(bytes.NewReader(data)).ReadPoint()
+	if _, ok := err.(DataError); !ok {
+		t.Errorf("ReadPoint of truncated timestamps err=%v, want DataError", err)
+	}
+}
+
+func TestSeriesMismatchedLengths(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w, err := NewSeriesWriter(buf, 4)
+	if err != nil {
+		t.Fatalf("NewSeriesWriter err=%q", err)
+	}
+	if n, err := w.WritePoints([]int64{1, 2}, []float64{1}); err == nil || n != 0 {
+		t.Errorf("WritePoints of mismatched slices n=%d err=%v, want an error", n, err)
+	}
+	if _, err := w.WritePoints([]int64{1, 2}, []float64{1, 2}); err != nil {
+		t.Fatalf("WritePoints err=%q", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+
+	r := NewSeriesReader(buf)
+	if n, err := r.ReadPoints(make([]int64, 1), make([]float64, 2)); err == nil || n != 0 {
+		t.Errorf("ReadPoints into mismatched slices n=%d err=%v, want an error", n, err)
+	}
+	ts, fs := make([]int64, 2), make([]float64, 2)
+	if n, err := r.ReadPoints(ts, fs); err != nil || n != 2 {
+		t.Fatalf("ReadPoints n=%d err=%v", n, err)
+	}
+	if ts[0] != 1 || ts[1] != 2 || fs[0] != 1 || fs[1] != 2 {
+		t.Errorf("ReadPoints read ts=%v fs=%v, want [1 2] [1 2]", ts, fs)
+	}
+}
@@ -0,0 +1,41 @@
+package fpc
+
+// Stats describe how well a stream has compressed so far. They are reported
+// by Writer.Stats and Reader.Stats.
+type Stats struct {
+	Values int64 // Count of values written, or decoded
+	Blocks int64 // Count of blocks written, or decoded
+
+	// UncompressedBytes is the size of the values counted by Values, and
+	// CompressedBytes is the size of the stream which holds them, including
+	// its header. A Writer only counts the compressed size of values once
+	// they have been flushed.
+	UncompressedBytes int64
+	CompressedBytes   int64
+
+	// Predictors counts how often each predictor made the best prediction
+	// for a value, in the order they are listed in the stream's header:
+	// FCM, then DFCM, then the two further predictors of extended streams.
+	Predictors [4]int64
+
+	// ResidualLengths counts values by the number of bytes needed to store
+	// the difference between the value and its prediction, from 0 to 8.
+	// Values which were predicted exactly have a residual length of 0.
+	ResidualLengths [9]int64
+}
+
+// Ratio returns the compression ratio achieved so far: the uncompressed size
+// of the stream divided by its compressed size. It returns zero if nothing
+// has been compressed.
+func (s Stats) Ratio() float64 {
+	if s.CompressedBytes == 0 {
+		return 0
+	}
+	return float64(s.UncompressedBytes) / float64(s.CompressedBytes)
+}
+
+// record counts a value which was encoded or decoded as described by h.
+func (s *Stats) record(h header) {
+	s.Predictors[h.pType]++
+	s.ResidualLengths[h.len]++
+}
@@ -0,0 +1,84 @@
+package fpc
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	for _, opts := range []WriterOptions{
+		{Level: DefaultCompression},
+		{Level: 8, Checksums: true},
+		{Level: 8, Extended: true},
+	} {
+		want := generateFloats(2*maxRecordsPerBlock + 7)
+
+		buf := new(bytes.Buffer)
+		w, err := NewWriterOptions(buf, opts)
+		if err != nil {
+			t.Fatalf("NewWriterOptions err=%q", err)
+		}
+		if _, err := w.WriteFloats(want); err != nil {
+			t.Fatalf("WriteFloats err=%q", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close err=%q", err)
+		}
+		ws := w.Stats()
+
+		if ws.Values != int64(len(want)) {
+			t.Errorf("Writer Stats opts=%+v Values=%d, want %d", opts, ws.Values, len(want))
+		}
+		if ws.Blocks != 3 {
+			t.Errorf("Writer Stats opts=%+v Blocks=%d, want 3", opts, ws.Blocks)
+		}
+		if ws.UncompressedBytes != 8*int64(len(want)) {
+			t.Errorf("Writer Stats opts=%+v UncompressedBytes=%d, want %d", opts, ws.UncompressedBytes, 8*len(want))
+		}
+		if ws.CompressedBytes != int64(buf.Len()) {
+			t.Errorf("Writer Stats opts=%+v CompressedBytes=%d, want %d", opts, ws.CompressedBytes, buf.Len())
+		}
+		var nPredicted, nResiduals int64
+		for _, n := range ws.Predictors {
+			nPredicted += n
+		}
+		for _, n := range ws.ResidualLengths {
+			nResiduals += n
+		}
+		if nPredicted != ws.Values || nResiduals != ws.Values {
+			t.Errorf("Writer Stats opts=%+v histograms count %d and %d values, want %d", opts, nPredicted, nResiduals, ws.Values)
+		}
+		if ws.Predictors[fcmPredictor] == 0 || ws.Predictors[dfcmPredictor] == 0 {
+			t.Errorf("Writer Stats opts=%+v Predictors=%v, want both FCM and DFCM used", opts, ws.Predictors)
+		}
+
+		r := NewReader(buf)
+		have := make([]float64, len(want)+1)
+		if _, err := r.ReadFloats(have); err != io.EOF {
+			t.Fatalf("ReadFloats err=%v, want io.EOF", err)
+		}
+		if rs := r.Stats(); rs != ws {
+			t.Errorf("Reader Stats opts=%+v\nhave %+v\nwant %+v", opts, rs, ws)
+		}
+
+		r.Reset(bytes.NewReader(nil))
+		if rs := r.Stats(); rs != (Stats{}) {
+			t.Errorf("Reader Stats after Reset=%+v, want zero", rs)
+		}
+		w.Reset(new(bytes.Buffer))
+		if ws := w.Stats(); ws != (Stats{}) {
+			t.Errorf("Writer Stats after Reset=%+v, want zero", ws)
+		}
+	}
+}
+
+func TestStatsRatio(t *testing.T) {
+	if r := (Stats{}).Ratio(); r != 0 {
+		t.Errorf("Ratio of empty Stats=%v, want 0", r)
+	}
+	s := Stats{UncompressedBytes: 800, CompressedBytes: 200}
+	if r := s.Ratio(); r != 4 {
+		t.Errorf("Ratio=%v, want 4", r)
+	}
+}
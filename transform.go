@@ -0,0 +1,106 @@
+package fpc
+
+import "fmt"
+
+// A Transform is applied to the bits of each value before it is compressed,
+// and undone after it is decompressed. Transforms help with integer data,
+// whose small values and steady changes FPC's predictors don't otherwise
+// take advantage of. The transform is recorded in the stream's header, so
+// streams with a transform always have a framed header.
+type Transform uint8
+
+const (
+	// NoTransform leaves values as they are.
+	NoTransform Transform = iota
+
+	// ZigZag treats each value as a signed integer, and zig-zag encodes
+	// it, so that values of small magnitude have leading zero bytes
+	// whatever their sign.
+	ZigZag
+
+	// DeltaZigZag replaces each value with the zig-zag encoded difference
+	// between it and the previous value, treating both as integers. It
+	// suits counters and other integers which change steadily.
+	DeltaZigZag
+)
+
+func (t Transform) String() string {
+	switch t {
+	case NoTransform:
+		return "none"
+	case ZigZag:
+		return "zigzag"
+	case DeltaZigZag:
+		return "delta-zigzag"
+	default:
+		return fmt.Sprintf("Transform(%d)", uint8(t))
+	}
+}
+
+// valid reports whether t is a known transform.
+func (t Transform) valid() bool {
+	return t <= DeltaZigZag
+}
+
+// A transformer applies a Transform to the values of a stream. It holds the
+// previous value, for delta transforms, so it must see every value in order.
+type transformer struct {
+	t     Transform
+	width uint   // size of each value in bits
+	prev  uint64 // previous untransformed value
+}
+
+func newTransformer(t Transform, e ElementType) *transformer {
+	if t == NoTransform {
+		return nil
+	}
+	return &transformer{t: t, width: uint(8 * e.size())}
+}
+
+// zigzag maps signed integers of the transformer's width to unsigned ones,
+// interleaving positive and negative values: 0, -1, 1, -2, 2, ...
+func (x *transformer) zigzag(v uint64) uint64 {
+	if x.width == 32 {
+		i := int32(v)
+		return uint64(uint32(i<<1) ^ uint32(i>>31))
+	}
+	i := int64(v)
+	return uint64(i<<1) ^ uint64(i>>63)
+}
+
+// unzigzag reverses zigzag.
+func (x *transformer) unzigzag(v uint64) uint64 {
+	u := (v >> 1) ^ -(v & 1)
+	if x.width == 32 {
+		u = uint64(uint32(u))
+	}
+	return u
+}
+
+// apply transforms v, before it is compressed.
+func (x *transformer) apply(v uint64) uint64 {
+	if x.t == DeltaZigZag {
+		v, x.prev = v-x.prev, v
+	}
+	return x.zigzag(v)
+}
+
+// undo reverses apply, after v is decompressed.
+func (x *transformer) undo(v uint64) uint64 {
+	v = x.unzigzag(v)
+	if x.t == DeltaZigZag {
+		v += x.prev
+		if x.width == 32 {
+			v = uint64(uint32(v))
+		}
+		x.prev = v
+	}
+	return v
+}
+
+// reset forgets the previous value, for the start of a stream or segment.
+func (x *transformer) reset() {
+	if x != nil {
+		x.prev = 0
+	}
+}
@@ -0,0 +1,165 @@
+package fpc
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestTransformZigZag(t *testing.T) {
+	x64 := newTransformer(ZigZag, Float64)
+	x32 := newTransformer(ZigZag, Float32)
+	testcases := []struct {
+		in   int64
+		want uint64
+	}{
+		{0, 0}, {-1, 1}, {1, 2}, {-2, 3}, {2, 4},
+		{math.MaxInt32, math.MaxUint32 - 1}, {math.MinInt32, math.MaxUint32},
+	}
+	for _, tc := range testcases {
+		if have := x64.apply(uint64(tc.in)); have != tc.want {
+			t.Errorf("64-bit zigzag(%d)=%d, want %d", tc.in, have, tc.want)
+		}
+		if have := x32.apply(uint64(uint32(tc.in))); have != tc.want {
+			t.Errorf("32-bit zigzag(%d)=%d, want %d", tc.in, have, tc.want)
+		}
+		if have := x64.undo(tc.want); have != uint64(tc.in) {
+			t.Errorf("64-bit unzigzag(%d)=%d, want %d", tc.want, have, tc.in)
+		}
+		if have := x32.undo(tc.want); have != uint64(uint32(tc.in)) {
+			t.Errorf("32-bit unzigzag(%d)=%d, want %d", tc.want, have, uint32(tc.in))
+		}
+	}
+}
+
+func TestWriteInt64s(t *testing.T) {
+	// Small values of either sign, whose bits differ in every byte when
+	// their signs do, and extremes which overflow the deltas.
+	rng := rand.New(rand.NewSource(1))
+	want := make([]int64, 3*maxRecordsPerBlock)
+	for i := range want {
+		want[i] = int64(rng.Intn(201) - 100)
+	}
+	want = append(want, math.MaxInt64, math.MinInt64, 0, -1)
+
+	sizes := make(map[Transform]int)
+	for _, transform := range []Transform{NoTransform, ZigZag, DeltaZigZag} {
+		buf := new(bytes.Buffer)
+		w, err := NewWriterOptions(buf, WriterOptions{Level: 10, Transform: transform})
+		if err != nil {
+			t.Fatalf("NewWriterOptions err=%q", err)
+		}
+		if n, err := w.WriteInt64s(want); err != nil || n != len(want) {
+			t.Fatalf("WriteInt64s n=%d err=%v", n, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close err=%q", err)
+		}
+		sizes[transform] = buf.Len()
+
+		r := NewReader(buf)
+		if h, _ := r.Header(); h.Transform != transform {
+			t.Errorf("Header Transform=%v, want %v", h.Transform, transform)
+		}
+		have := make([]int64, len(want)+1)
+		n, err := r.ReadInt64s(have)
+		if err != io.EOF || n != len(want) {
+			t.Fatalf("ReadInt64s transform=%v n=%d err=%v", transform, n, err)
+		}
+		for i := range want {
+			if have[i] != want[i] {
+				t.Fatalf("value mismatch transform=%v idx=%d have=%d want=%d", transform, i, have[i], want[i])
+			}
+		}
+	}
+	if sizes[DeltaZigZag] >= sizes[NoTransform] || sizes[ZigZag] >= sizes[NoTransform] {
+		t.Errorf("transformed sizes %v, want less than untransformed %d", sizes, sizes[NoTransform])
+	}
+}
+
+func TestWriteUint64sParallel(t *testing.T) {
+	// A counter, split into segments which each restart the deltas.
+	want := make([]uint64, 2*DefaultSegmentSize+11)
+	for i := range want {
+		want[i] = 1<<40 + uint64(i)*3
+	}
+
+	buf := new(bytes.Buffer)
+	w, err := NewParallelWriterOptions(buf, WriterOptions{Level: 10, Transform: DeltaZigZag}, 2)
+	if err != nil {
+		t.Fatalf("NewParallelWriterOptions err=%q", err)
+	}
+	for _, v := range want {
+		if err := w.WriteFloat(math.Float64frombits(v)); err != nil {
+			t.Fatalf("WriteFloat err=%q", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+	if buf.Len() > len(want) {
+		t.Errorf("counter takes %d bytes, want at most %d", buf.Len(), len(want))
+	}
+
+	r, err := NewParallelReader(bytes.NewReader(buf.Bytes()), 2)
+	if err != nil {
+		t.Fatalf("NewParallelReader err=%q", err)
+	}
+	defer r.Close()
+	have := make([]float64, len(want))
+	if _, err := r.ReadFloats(have); err != nil {
+		t.Fatalf("ReadFloats err=%q", err)
+	}
+	for i := range want {
+		if v := math.Float64bits(have[i]); v != want[i] {
+			t.Fatalf("value mismatch idx=%d have=%d want=%d", i, v, want[i])
+		}
+	}
+
+	// The sequential Reader agrees.
+	vs := make([]uint64, len(want))
+	if _, err := NewReader(bytes.NewReader(buf.Bytes())).ReadUint64s(vs); err != nil {
+		t.Fatalf("ReadUint64s err=%q", err)
+	}
+	for i := range want {
+		if vs[i] != want[i] {
+			t.Fatalf("value mismatch idx=%d have=%d want=%d", i, vs[i], want[i])
+		}
+	}
+}
+
+func TestTransform32(t *testing.T) {
+	want := []float32{0, 1, -1, float32(math.Inf(-1)), math.MaxFloat32, 1e-40, 2.5}
+	buf := new(bytes.Buffer)
+	w, err := NewWriter32Options(buf, WriterOptions{Level: 4, Transform: DeltaZigZag})
+	if err != nil {
+		t.Fatalf("NewWriter32Options err=%q", err)
+	}
+	w.WriteFloat32s(want)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+	have := make([]float32, len(want))
+	if _, err := NewReader32(buf).ReadFloat32s(have); err != nil {
+		t.Fatalf("ReadFloat32s err=%q", err)
+	}
+	for i := range want {
+		if math.Float32bits(have[i]) != math.Float32bits(want[i]) {
+			t.Errorf("value mismatch idx=%d have=%v want=%v", i, have[i], want[i])
+		}
+	}
+}
+
+func TestTransformInvalid(t *testing.T) {
+	if _, err := NewWriterOptions(new(bytes.Buffer), WriterOptions{Level: 4, Transform: DeltaZigZag + 1}); err == nil {
+		t.Errorf("NewWriterOptions should refuse an unknown transform")
+	}
+	for _, b := range []byte{0, byte(DeltaZigZag + 1)} {
+		in := []byte{0xFF, 'F', 'P', 'C', FramedVersion, 0, 0x04, 0, 10, b}
+		if _, err := ReadHeader(bytes.NewReader(in)); err == nil {
+			t.Errorf("ReadHeader of transform %d should fail", b)
+		}
+	}
+}
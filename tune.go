@@ -0,0 +1,131 @@
+package fpc
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// Candidate shifts tried by TuneHashShifts, around the defaults.
+var (
+	tuneHistoryShifts = []uint8{1, 2, 4, 6, 8}
+	tuneValueShifts   = []uint8{28, 32, 36, 40, 44, 48, 52}
+)
+
+// TuneHashShifts picks hash shifts for the FCM and DFCM predictors which
+// compress sample well at the given compression level, by compressing it
+// with several candidates and choosing the one which gives the smallest
+// output. The sample should be representative of the data to be compressed,
+// and should be large enough to fill the predictors' tables; compressing it
+// is repeated a few dozen times.
+//
+// The result can be used as WriterOptions.HashShifts. If none of the
+// candidates beat the defaults, TuneHashShifts returns the zero HashShifts,
+// which selects the defaults. It returns an error if an invalid compression
+// level is provided.
+func TuneHashShifts(sample []float64, level int) (HashShifts, error) {
+	if level < 1 || level > MaxCompression {
+		return HashShifts{}, fmt.Errorf("fpc: invalid compression level: %d", level)
+	}
+	vals := make([]uint64, len(sample))
+	for i, f := range sample {
+		vals[i] = math.Float64bits(f)
+	}
+
+	best := defaultHashShifts(Float64)
+	bestSize := compressedSize(vals, uint(level), best)
+	defaultSize := bestSize
+	try := func(s HashShifts) {
+		if size := compressedSize(vals, uint(level), s); size < bestSize {
+			best, bestSize = s, size
+		}
+	}
+
+	// Tune each predictor's hash in turn, since the predictors are mostly
+	// independent of one another.
+	base := best
+	for _, history := range tuneHistoryShifts {
+		for _, value := range tuneValueShifts {
+			s := base
+			s.FCMHistory, s.FCMValue = history, value
+			try(s)
+		}
+	}
+	base = best
+	for _, history := range tuneHistoryShifts {
+		for _, value := range tuneValueShifts {
+			s := base
+			s.DFCMHistory, s.DFCMValue = history, value
+			try(s)
+		}
+	}
+
+	if bestSize == defaultSize {
+		return HashShifts{}, nil
+	}
+	return best, nil
+}
+
+// levelTolerance is how much larger than the best compressed size
+// SuggestLevel will accept in exchange for a lower level: gains from higher
+// levels smaller than this are treated as noise.
+const levelTolerance = 0.01
+
+// SuggestLevel picks a compression level for data like sample, by
+// compressing it at each level and choosing the lowest one which compresses
+// it within 1% as well as any other. Higher levels need exponentially more
+// memory, and beyond some level, which depends on the data, they stop
+// paying off.
+//
+// Levels whose predictor tables would take more than maxMemory bytes, like
+// ReaderOptions.MaxMemory, aren't considered, unless maxMemory is zero.
+// Neither are levels whose tables have many more entries than sample has
+// values, since sample can't show whether they would help; the sample
+// should be as large as the data which will be compressed at once, or at
+// least several times larger than the tables at the levels of interest.
+// SuggestLevel returns 1 if no level fits within maxMemory.
+func SuggestLevel(sample []float64, maxMemory int64) int {
+	maxLevel := bits.Len(uint(len(sample))) + 1
+	if maxLevel > MaxCompression {
+		maxLevel = MaxCompression
+	}
+	for maxLevel > 1 && maxMemory > 0 && tableMemory(uint(maxLevel)) > maxMemory {
+		maxLevel--
+	}
+
+	vals := make([]uint64, len(sample))
+	for i, f := range sample {
+		vals[i] = math.Float64bits(f)
+	}
+	sizes := make([]int, maxLevel+1)
+	best := 0
+	for level := 1; level <= maxLevel; level++ {
+		sizes[level] = compressedSize(vals, uint(level), HashShifts{})
+		if best == 0 || sizes[level] < sizes[best] {
+			best = level
+		}
+	}
+	for level := 1; level < best; level++ {
+		if float64(sizes[level]) <= float64(sizes[best])*(1+levelTolerance) {
+			return level
+		}
+	}
+	return best
+}
+
+// compressedSize returns the number of bytes taken by the encoded records of
+// vals, when compressed with the given level and hash shifts.
+func compressedSize(vals []uint64, level uint, s HashShifts) int {
+	fcm, dfcm := newPredictors(level, Float64, s)
+	enc := newEncoderPredictors(Float64, []predictor{fcm, dfcm})
+	size := (len(vals) + 1) / 2 // record headers
+	for i := 0; i+1 < len(vals); i += 2 {
+		_, data := enc.encode(vals[i], vals[i+1])
+		size += len(data)
+	}
+	if len(vals)%2 == 1 {
+		h, _ := enc.encode(vals[len(vals)-1], 0)
+		size += int(h.h1.len)
+	}
+	return size
+}
@@ -0,0 +1,131 @@
+package fpc
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestTuneHashShifts(t *testing.T) {
+	// A smooth field with a little low-order noise.
+	want := make([]float64, 50000)
+	for i := range want {
+		want[i] = 280 + 15*math.Sin(float64(i)/500) + float64(i%7)*1e-3
+	}
+	shifts, err := TuneHashShifts(want[:10000], 12)
+	if err != nil {
+		t.Fatalf("TuneHashShifts err=%q", err)
+	}
+	if err := shifts.check(); err != nil {
+		t.Fatalf("TuneHashShifts returned invalid shifts %+v: %q", shifts, err)
+	}
+
+	vals := make([]uint64, len(want))
+	for i, f := range want {
+		vals[i] = math.Float64bits(f)
+	}
+	tuned := compressedSize(vals, 12, shifts)
+	untuned := compressedSize(vals, 12, HashShifts{})
+	if tuned > untuned {
+		t.Errorf("tuned shifts %+v give %d bytes, more than %d with the defaults", shifts, tuned, untuned)
+	}
+
+	buf := new(bytes.Buffer)
+	w, err := NewWriterOptions(buf, WriterOptions{Level: 12, HashShifts: shifts})
+	if err != nil {
+		t.Fatalf("NewWriterOptions err=%q", err)
+	}
+	w.WriteFloats(want)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+	r := NewReader(buf)
+	if h, _ := r.Header(); h.HashShifts != shifts {
+		t.Errorf("Header HashShifts=%+v, want %+v", h.HashShifts, shifts)
+	}
+	have := make([]float64, len(want))
+	if _, err := r.ReadFloats(have); err != nil {
+		t.Fatalf("ReadFloats err=%q", err)
+	}
+	for i := range want {
+		if have[i] != want[i] {
+			t.Fatalf("value mismatch idx=%d have=%v want=%v", i, have[i], want[i])
+		}
+	}
+}
+
+func TestHashShiftsRoundTrip(t *testing.T) {
+	for _, shifts := range []HashShifts{
+		{FCMHistory: 4, FCMValue: 40, DFCMHistory: 1, DFCMValue: 32},
+		{FCMHistory: 0, FCMValue: 0, DFCMHistory: 0, DFCMValue: 63},
+	} {
+		for _, tc := range refTests {
+			buf := new(bytes.Buffer)
+			w, err := NewWriterOptions(buf, WriterOptions{Level: int(tc.comp), HashShifts: shifts})
+			if err != nil {
+				t.Fatalf("NewWriterOptions err=%q", err)
+			}
+			w.WriteFloats(tc.uncompressed)
+			tc.AssertNoError(t, w.Close(), "Close")
+
+			have := make([]float64, len(tc.uncompressed))
+			_, err = NewReader(buf).ReadFloats(have)
+			tc.AssertNoError(t, err, "ReadFloats")
+			tc.AssertEqual(t, have, tc.uncompressed, "Reader")
+		}
+	}
+
+	_, err := NewWriterOptions(new(bytes.Buffer), WriterOptions{Level: 1, HashShifts: HashShifts{FCMValue: 64}})
+	if err == nil {
+		t.Errorf("NewWriterOptions should refuse a shift of 64")
+	}
+}
+
+func TestSuggestLevel(t *testing.T) {
+	// A pattern of random values, repeated. FCM can predict the repeats
+	// only if its table is large enough to hold the whole pattern.
+	rng := rand.New(rand.NewSource(1))
+	pattern := make([]float64, 3000)
+	for i := range pattern {
+		pattern[i] = rng.NormFloat64()
+	}
+	sample := make([]float64, 0, 20*len(pattern))
+	for i := 0; i < 20; i++ {
+		sample = append(sample, pattern...)
+	}
+	vals := make([]uint64, len(sample))
+	for i, f := range sample {
+		vals[i] = math.Float64bits(f)
+	}
+
+	level := SuggestLevel(sample, 0)
+	if level < 12 || level > MaxCompression {
+		t.Fatalf("SuggestLevel=%d, want at least 12", level)
+	}
+	size := compressedSize(vals, uint(level), HashShifts{})
+	for l := 1; l <= level+4; l++ {
+		if other := compressedSize(vals, uint(l), HashShifts{}); float64(size) > 1.01*float64(other) {
+			t.Errorf("SuggestLevel=%d gives %d bytes, but level %d gives %d", level, size, l, other)
+		}
+	}
+
+	if level := SuggestLevel(sample, tableMemory(8)); level > 8 {
+		t.Errorf("SuggestLevel with memory for level 8=%d, want at most 8", level)
+	}
+	if level := SuggestLevel(sample, 1); level != 1 {
+		t.Errorf("SuggestLevel with no memory=%d, want 1", level)
+	}
+	if level := SuggestLevel(nil, 0); level != 1 {
+		t.Errorf("SuggestLevel of empty sample=%d, want 1", level)
+	}
+}
+
+func TestTuneHashShiftsInvalidLevel(t *testing.T) {
+	sample := generateFloats(1000)
+	for _, level := range []int{-1, 0, MaxCompression + 1} {
+		if _, err := TuneHashShifts(sample, level); err == nil {
+			t.Errorf("TuneHashShifts level=%d should fail", level)
+		}
+	}
+}
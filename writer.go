@@ -18,12 +18,138 @@ const (
 	floatChunkSize = 8
 )
 
+// WriterOptions configure the format of the stream written by a Writer.
+type WriterOptions struct {
+	// Level is the compression level to use. Higher compression levels
+	// will result in more compressed data, but require exponentially more
+	// memory. The space required is O(2^Level) bytes.
+	Level int
+
+	// Checksums enables writing a CRC-32C checksum after each block, which
+	// allows readers to detect corrupted data. Streams with checksums cannot
+	// be read by the reference implementation.
+	Checksums bool
+
+	// Framed enables writing a self-describing header, which identifies the
+	// stream as FPC data and records its format. Framed streams cannot be
+	// read by the reference implementation.
+	Framed bool
+
+	// Extended enables an extended format, in which each record chooses
+	// among four predictors rather than two: by default, FCM, DFCM,
+	// LastValue and Stride. Each record header takes 5 bits rather than
+	// 4, so this pays off only when the extra predictors are often exact
+	// where FCM and DFCM are not. Extended streams always have a framed
+	// header.
+	Extended bool
+
+	// Predictors names the predictors to use in place of the defaults:
+	// two of them, or four for extended streams. Names other than those of
+	// the built-in predictors must have been registered with
+	// RegisterPredictor. The names are recorded in the stream's header, so
+	// a framed header is always written when Predictors is set.
+	Predictors []string
+
+	// HashShifts configure the hash functions of the FCM and DFCM
+	// predictors. The zero value selects the defaults; TuneHashShifts can
+	// pick better ones for a sample of the data. Shifts other than the
+	// defaults are recorded in the stream's header, so a framed header is
+	// always written when HashShifts is set.
+	HashShifts HashShifts
+
+	// MantissaBits, RelativeError and AbsoluteError make the stream
+	// lossy: values are rounded before they are compressed, so that they
+	// compress better. At most one of them may be set.
+	//
+	// MantissaBits keeps only the given number of the most significant
+	// mantissa bits of each value, from 1 to 52. RelativeError keeps
+	// enough of them that each value is within the given fraction of its
+	// magnitude. Both of these also record a rotation in the stream's
+	// header, which needs a framed header.
+	//
+	// AbsoluteError rounds each value to within the given distance of its
+	// original. Its streams can be read by any FPC reader.
+	//
+	// Values which can't be rounded within the bound, like infinities and
+	// some subnormal values, are kept exactly.
+	MantissaBits  int
+	RelativeError float64
+	AbsoluteError float64
+
+	// Transform is applied to the bits of each value before it is
+	// compressed, and undone by readers. Transforms suit integer data,
+	// written with WriteUint64s or WriteInt64s. The transform is recorded
+	// in the stream's header, so a framed header is always written when
+	// Transform is set.
+	Transform Transform
+
+	// Dictionary, if set, holds predictor state for streams to start
+	// from, rather than empty tables. Level and HashShifts must match the
+	// dictionary's. The dictionary's ID is recorded in the stream's
+	// header, so a framed header is always written when Dictionary is set,
+	// and the stream can only be read by a Reader with the same
+	// dictionary. A ParallelWriter can't use a Dictionary.
+	Dictionary *Dictionary
+
+	channels   int  // number of interleaved channels, set by NewMultiWriterOptions
+	timestamps bool // whether values have timestamps, set by NewSeriesWriterOptions
+}
+
+// validate returns an error if o doesn't describe a valid stream.
+func (o WriterOptions) validate() error {
+	if o.Level < 1 || o.Level > MaxCompression {
+		return fmt.Errorf("fpc: invalid compression level: %d", o.Level)
+	}
+	if err := o.HashShifts.check(); err != nil {
+		return err
+	}
+	if err := o.checkLossy(); err != nil {
+		return err
+	}
+	if !o.Transform.valid() {
+		return fmt.Errorf("fpc: invalid transform: %v", o.Transform)
+	}
+	if len(o.Predictors) > 0 {
+		return checkPredictors(o.Predictors, numPredictors(o.Extended))
+	}
+	return nil
+}
+
+// header returns the header of a stream of values of type t written with o.
+func (o WriterOptions) header(t ElementType) Header {
+	h := Header{
+		Level:       o.Level,
+		ElementType: t,
+		Checksums:   o.Checksums,
+		Extended:    o.Extended,
+		Predictors:  o.Predictors,
+		HashShifts:  o.HashShifts,
+		Rotation:    o.quantizer(t).rotation(),
+		Transform:   o.Transform,
+	}
+	if o.Dictionary != nil {
+		h.DictionaryID = o.Dictionary.ID()
+	}
+	if o.channels > 1 {
+		h.Channels = o.channels
+	}
+	h.Timestamps = o.timestamps
+	h.Framed = o.Framed || o.Extended || len(o.Predictors) > 0 ||
+		o.HashShifts != (HashShifts{}) || h.Rotation != 0 || h.Channels != 0 ||
+		h.Timestamps || h.Transform != NoTransform || h.DictionaryID != 0
+	if h.Framed {
+		h.Version = FramedVersion
+	}
+	return h
+}
+
 // A Writer is an io.WriteCloser which FPC-compresses data it receives
 // and writes it to an underlying writer, w.  Writes to a Writer are
 type Writer struct {
-	w     io.Writer
-	level int
-	enc   *blockEncoder
+	w    io.Writer
+	opts WriterOptions
+	elem ElementType
+	enc  *blockEncoder
 
 	wroteHeader bool
 	closed      bool
@@ -42,13 +168,52 @@ func NewWriter(w io.Writer) *Writer {
 // memory. The space required is O(2^level) bytes. NewWriterLevel
 // returns an error if an invalid compression level is provided.
 func NewWriterLevel(w io.Writer, level int) (*Writer, error) {
-	if level < 1 || level > MaxCompression {
-		return nil, fmt.Errorf("fpc: invalid compression level: %d", level)
+	return NewWriterOptions(w, WriterOptions{Level: level})
+}
+
+// NewWriterOptions makes a new Writer which writes compressed data to w
+// in the format described by opts. NewWriterOptions returns an error if
+// opts are invalid.
+func NewWriterOptions(w io.Writer, opts WriterOptions) (*Writer, error) {
+	return newWriter(w, opts, Float64)
+}
+
+// NewWriterDict makes a new Writer which writes compressed data to w,
+// starting from the predictor state in dict, at the dictionary's compression
+// level. The stream can only be read by a Reader made with NewReaderDict
+// and the same dictionary. It returns an error if dict is nil.
+func NewWriterDict(w io.Writer, dict *Dictionary) (*Writer, error) {
+	if dict == nil {
+		return nil, errors.New("fpc: NewWriterDict needs a Dictionary")
+	}
+	opts := WriterOptions{Level: dict.Level(), Dictionary: dict}
+	if s := dict.HashShifts(); s != defaultHashShifts(Float64) {
+		opts.HashShifts = s
+	}
+	return NewWriterOptions(w, opts)
+}
+
+// newWriter makes a new Writer which writes a stream of values of type t.
+func newWriter(w io.Writer, opts WriterOptions, t ElementType) (*Writer, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
 	}
+	if opts.Dictionary != nil {
+		if err := opts.Dictionary.check(opts.header(t)); err != nil {
+			return nil, err
+		}
+	}
+	enc, err := newBlockEncoderHeader(w, opts.header(t))
+	if err != nil {
+		return nil, err
+	}
+	enc.quant = opts.quantizer(t)
+	enc.enc.setDictionary(opts.Dictionary)
 	z := &Writer{
-		w:     w,
-		level: level,
-		enc:   newBlockEncoder(w, uint(level)),
+		w:    w,
+		opts: opts,
+		elem: t,
+		enc:  enc,
 	}
 	return z, nil
 }
@@ -68,11 +233,63 @@ func (w *Writer) Write(b []byte) (int, error) {
 	return len(b), nil
 }
 
+// ReadFrom reads byte-encoded, 64-bit IEEE 754 floating point values from r
+// until io.EOF, and writes them to the encoded stream. Unlike Write, it
+// accepts reads of any length, carrying partial values over to the next
+// read; it returns an error only if the data ends partway through a value.
+// It returns the number of bytes read from r. It implements io.ReaderFrom,
+// so io.Copy uses it.
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
+	if err := w.ensureHeader(); err != nil {
+		return 0, err
+	}
+	return readValues(r, w.enc.encode)
+}
+
 // WriteFloat writes a single float64 value to the encoded stream.
 func (w *Writer) WriteFloat(f float64) error {
 	return w.writeFloat64(f)
 }
 
+// WriteFloats writes a slice of float64 values to the encoded stream. It
+// returns the number of values written.
+func (w *Writer) WriteFloats(fs []float64) (int, error) {
+	if err := w.ensureHeader(); err != nil {
+		return 0, err
+	}
+	return w.enc.encodeFloats(fs)
+}
+
+// WriteUint64s writes a slice of uint64 values to the encoded stream. Their
+// bits are compressed just like those of float64 values, so integers can
+// share a codec with floats, but they may compress better with a Transform
+// in the WriterOptions. It returns the number of values written.
+func (w *Writer) WriteUint64s(vs []uint64) (int, error) {
+	if err := w.ensureHeader(); err != nil {
+		return 0, err
+	}
+	for i, v := range vs {
+		if err := w.enc.encode(v); err != nil {
+			return i, err
+		}
+	}
+	return len(vs), nil
+}
+
+// WriteInt64s writes a slice of int64 values to the encoded stream, like
+// WriteUint64s. It returns the number of values written.
+func (w *Writer) WriteInt64s(vs []int64) (int, error) {
+	if err := w.ensureHeader(); err != nil {
+		return 0, err
+	}
+	for i, v := range vs {
+		if err := w.enc.encode(uint64(v)); err != nil {
+			return i, err
+		}
+	}
+	return len(vs), nil
+}
+
 // Flush will make sure all internally-buffered values are written to
 // w. FPC's format specifies that data get written in blocks; calling
 // Flush will write the current data to a block, even if it results in
@@ -98,10 +315,30 @@ func (w *Writer) Close() error {
 	return w.Flush()
 }
 
+// Reset discards the Writer's state and makes it equivalent to the result
+// of its original constructor, but writing to dst instead. Any values which
+// haven't been flushed are dropped. Reset reuses the Writer's predictor
+// tables, which makes it much cheaper than making a new Writer.
+func (w *Writer) Reset(dst io.Writer) {
+	w.w = dst
+	w.enc.reset(dst)
+	w.wroteHeader = false
+	w.closed = false
+}
+
+// Stats reports what the Writer has compressed since it was created or last
+// reset.
+func (w *Writer) Stats() Stats {
+	s := w.enc.stats
+	s.UncompressedBytes = s.Values * int64(w.elem.size())
+	return s
+}
+
 func (w *Writer) ensureHeader() error {
 	if !w.wroteHeader {
 		w.wroteHeader = true
-		_, err := w.w.Write([]byte{byte(w.level)})
+		n, err := w.w.Write(w.opts.header(w.elem).encode())
+		w.enc.stats.CompressedBytes += int64(n)
 		if err != nil {
 			return err
 		}
@@ -123,6 +360,36 @@ func (w *Writer) writeUint64(u uint64) error {
 	return nil
 }
 
+// readValues reads byte-encoded, 64-bit values from r until io.EOF, passing
+// each one to put. Values may be split across reads. It returns the number
+// of bytes read.
+func readValues(r io.Reader, put func(uint64) error) (int64, error) {
+	buf := make([]byte, floatChunkSize*maxRecordsPerBlock)
+	var n int64
+	have := 0 // bytes in buf
+	for {
+		nr, err := r.Read(buf[have:])
+		n += int64(nr)
+		have += nr
+		whole := have - have%floatChunkSize
+		for i := 0; i < whole; i += floatChunkSize {
+			if perr := put(binary.LittleEndian.Uint64(buf[i:])); perr != nil {
+				return n, perr
+			}
+		}
+		// Keep any partial value for the next read.
+		have = copy(buf, buf[whole:have])
+		if err == io.EOF {
+			if have != 0 {
+				return n, errors.New("fpc: data ends partway through a value")
+			}
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+	}
+}
+
 // writeBytes writes a single 8-byte encoded IEEE 754 float
 func (w *Writer) writeBytes(b []byte) error {
 	if err := w.writeUint64(binary.LittleEndian.Uint64(b)); err != nil {
@@ -2,7 +2,11 @@ package fpc
 
 import (
 	"bytes"
+	"io"
+	"io/ioutil"
+	"math"
 	"testing"
+	"testing/iotest"
 )
 
 func TestWriter(t *testing.T) {
@@ -22,3 +26,122 @@ func TestWriter(t *testing.T) {
 		tc.AssertEqual(t, have.Bytes(), tc.compressed, "Writer")
 	}
 }
+
+func TestWriterFlushOdd(t *testing.T) {
+	// Flushing after an odd number of values writes a block whose last
+	// record has no partner, which must not disturb the predictors.
+	want := make([]float64, 1000)
+	for i := range want {
+		want[i] = float64(i*i%1013) / 7
+	}
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	for i, f := range want {
+		if err := w.WriteFloat(f); err != nil {
+			t.Fatalf("WriteFloat err=%q", err)
+		}
+		if i%7 == 6 {
+			if err := w.Flush(); err != nil {
+				t.Fatalf("Flush err=%q", err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close err=%q", err)
+	}
+
+	have := make([]float64, len(want))
+	if _, err := NewReader(buf).ReadFloats(have); err != nil {
+		t.Fatalf("ReadFloats err=%q", err)
+	}
+	for i := range want {
+		if have[i] != want[i] {
+			t.Fatalf("value mismatch idx=%d have=%v want=%v", i, have[i], want[i])
+		}
+	}
+}
+
+func TestWriteFloats(t *testing.T) {
+	for _, tc := range refTests {
+		have := bytes.NewBuffer(nil)
+		w, err := NewWriterLevel(have, int(tc.comp))
+		if err != nil {
+			t.Fatalf("NewWriterLevel err=%q", err)
+		}
+		n, err := w.WriteFloats(tc.uncompressed)
+		tc.AssertNoError(t, err, "WriteFloats")
+		if n != len(tc.uncompressed) {
+			t.Errorf("WriteFloats n=%d, want %d", n, len(tc.uncompressed))
+		}
+		err = w.Close()
+		tc.AssertNoError(t, err, "Close")
+
+		tc.AssertEqual(t, have.Bytes(), tc.compressed, "Writer")
+	}
+}
+
+func TestWriterReset(t *testing.T) {
+	w, err := NewWriterLevel(ioutil.Discard, 1)
+	if err != nil {
+		t.Fatalf("NewWriterLevel err=%q", err)
+	}
+	for _, tc := range refTests {
+		if tc.comp != 1 {
+			continue
+		}
+		// Leave some unflushed values behind, which Reset should discard.
+		w.WriteFloat(1)
+
+		have := bytes.NewBuffer(nil)
+		w.Reset(have)
+		_, err = w.WriteFloats(tc.uncompressed)
+		tc.AssertNoError(t, err, "WriteFloats")
+		err = w.Close()
+		tc.AssertNoError(t, err, "Close")
+
+		tc.AssertEqual(t, have.Bytes(), tc.compressed, "Writer")
+	}
+}
+
+// floatBytes returns fs as bytes, as Writer.Write expects them.
+func floatBytes(fs []float64) []byte {
+	b := make([]byte, 8*len(fs))
+	for i, f := range fs {
+		byteOrder.PutUint64(b[8*i:], math.Float64bits(f))
+	}
+	return b
+}
+
+func TestWriterReadFrom(t *testing.T) {
+	for _, tc := range refTests {
+		raw := floatBytes(tc.uncompressed)
+		// Reads which split values across them should be handled.
+		for _, src := range []io.Reader{
+			bytes.NewReader(raw),
+			iotest.OneByteReader(bytes.NewReader(raw)),
+			iotest.HalfReader(bytes.NewReader(raw)),
+		} {
+			have := bytes.NewBuffer(nil)
+			w, err := NewWriterLevel(have, int(tc.comp))
+			if err != nil {
+				t.Fatalf("NewWriterLevel err=%q", err)
+			}
+			n, err := w.ReadFrom(src)
+			tc.AssertNoError(t, err, "ReadFrom")
+			if n != int64(len(raw)) {
+				t.Errorf("ReadFrom n=%d, want %d", n, len(raw))
+			}
+			err = w.Close()
+			tc.AssertNoError(t, err, "Close")
+
+			tc.AssertEqual(t, have.Bytes(), tc.compressed, "Writer")
+		}
+	}
+
+	// Data which ends partway through a value is an error.
+	w := NewWriter(ioutil.Discard)
+	raw := floatBytes(generateFloats(10))
+	if _, err := w.ReadFrom(bytes.NewReader(raw[:len(raw)-3])); err == nil {
+		t.Errorf("ReadFrom of a partial value should fail")
+	}
+}